@@ -21,24 +21,33 @@ package bunch
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/bcogs/golibs/oil"
+	"github.com/fsnotify/fsnotify"
 )
 
 // Bunch represents a directory and the bunch of files it contains.
 type Bunch struct {
 	Root string // root directory of the Bunch
+
+	options Options
 }
 
 // Options contains possible options when instantiating a Bunch.
-type Options struct{}
+type Options struct {
+	// if > 0, Watch rescans the whole Bunch periodically, with that interval,
+	// to report the files whose fsnotify events were missed
+	WatchRescanInterval time.Duration
+}
 
 // NewBunch creates a new Bunch.  The root directory must exist.
 func NewBunch(root string, o *Options) (*Bunch, error) {
@@ -49,7 +58,11 @@ func NewBunch(root string, o *Options) (*Bunch, error) {
 	if !fi.IsDir() {
 		return nil, fmt.Errorf("%q isn't a directory", fi.Name())
 	}
-	return &Bunch{Root: root}, nil
+	b := &Bunch{Root: root}
+	if o != nil {
+		b.options = *o
+	}
+	return b, nil
 }
 
 // CleanGarbage deletes all garbage in the Bunch (typically, garbage is created when somethng starts to write a file and dies before it renames the temporary file).
@@ -103,6 +116,207 @@ func (b *Bunch) Walk(fn fs.WalkDirFunc) error {
 	return oil.If(err != nil, err, finalErr)
 }
 
+// Watch watches the Bunch for new files, and sends the relative path of each
+// of them to the returned channel, until the context is done, at which point it
+// closes the channel.  The channel must be read, otherwise watching gets stuck.
+// Files written with Write are reported once renamed to their final name, and
+// temporary or garbage files, whose name start with a dot, are skipped, as are
+// the files that already exist when Watch is called.
+// A file is reported again if it's overwritten with a different modification time.
+// Watching is implemented with fsnotify, by watching every directory of the
+// Bunch, which has caveats: on Linux, the number of watched directories is
+// limited by the fs.inotify.max_user_watches sysctl, on BSD and macOS, kqueue
+// uses a file descriptor per watched file, and on all platforms, events may be
+// dropped under heavy load.  Set the WatchRescanInterval option to rescan the
+// whole Bunch periodically and report the files whose events were missed.
+// Watch remembers all the files it reports (or all the files of the Bunch if
+// WatchRescanInterval is set), so its memory use grows with their number.
+func (b *Bunch) Watch(ctx context.Context) (<-chan []string, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("creating fsnotify watcher failed - %w", err)
+	}
+	bw := &bunchWatcher{b: b, w: w, out: make(chan []string, 64), seen: make(map[string]time.Time)}
+	if err := bw.walk(ctx, b.Root, false); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("watching %s failed - %w", b.Root, err)
+	}
+	go bw.run(ctx)
+	return bw.out, nil
+}
+
+type bunchWatcher struct {
+	b    *Bunch
+	w    *fsnotify.Watcher
+	out  chan []string
+	seen map[string]time.Time // modification time of the files already reported
+}
+
+func (bw *bunchWatcher) run(ctx context.Context) {
+	defer close(bw.out)
+	defer bw.w.Close()
+	var rescan <-chan time.Time
+	if bw.b.options.WatchRescanInterval > 0 {
+		ticker := time.NewTicker(bw.b.options.WatchRescanInterval)
+		defer ticker.Stop()
+		rescan = ticker.C
+	}
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-bw.w.Events:
+			if !ok {
+				return
+			}
+			if ev.Has(fsnotify.Create) && !isDotFile(ev.Name) {
+				err = bw.walk(ctx, ev.Name, true)
+			}
+		case _, ok := <-bw.w.Errors: // typically, dropped events, which rescans take care of
+			if !ok {
+				return
+			}
+		case <-rescan:
+			err = bw.walk(ctx, bw.b.Root, true)
+		}
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// walk watches all the directories under a path (which can be a file), and
+// reports the files if report is true, or just remembers them if there are
+// periodic rescans.
+func (bw *bunchWatcher) walk(ctx context.Context, root string, report bool) error {
+	return filepath.WalkDir(root, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return oil.If(path == root, err, nil)
+		}
+		if path != root && isDotFile(path) {
+			return oil.If(de.IsDir(), fs.SkipDir, nil)
+		}
+		if de.IsDir() {
+			if err := bw.w.Add(path); err != nil && !report {
+				return err
+			}
+			return nil
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return nil // the file was probably removed in the mean time
+		}
+		if !report {
+			if bw.b.options.WatchRescanInterval > 0 {
+				bw.seen[path] = fi.ModTime()
+			}
+			return nil
+		}
+		if t, ok := bw.seen[path]; ok && t.Equal(fi.ModTime()) {
+			return nil
+		}
+		bw.seen[path] = fi.ModTime()
+		rel, err := filepath.Rel(bw.b.Root, path)
+		if err != nil {
+			return err
+		}
+		select {
+		case bw.out <- strings.Split(rel, string(filepath.Separator)):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+func isDotFile(path string) bool { return strings.HasPrefix(filepath.Base(path), ".") }
+
+// VerifyReport lists the anomalies found in a Bunch by Verify, as relative paths.
+type VerifyReport struct {
+	TempFiles  [][]string // temporary or garbage files or directories, whose name start with a dot
+	EmptyFiles [][]string // regular files of 0 bytes
+	EmptyDirs  [][]string // directories without regular files, even in subdirectories
+}
+
+// Verify walks the Bunch and reports anomalies, typically left by crashes,
+// without changing anything.
+// Like CleanGarbage, it doesn't stop at the first error, but returns it along
+// with the report of everything it could walk.
+func (b *Bunch) Verify() (VerifyReport, error) {
+	var report VerifyReport
+	var finalErr error
+	var dirs []string
+	nonEmptyDirs, root := make(map[string]bool), filepath.Clean(b.Root)
+	err := filepath.WalkDir(b.Root, func(path string, de fs.DirEntry, err error) error {
+		if err != nil {
+			if finalErr == nil {
+				finalErr = err
+			}
+			return nil
+		}
+		if path == b.Root {
+			return nil
+		}
+		rel, err := filepath.Rel(b.Root, path)
+		if err != nil {
+			return err
+		}
+		relPath := strings.Split(rel, string(filepath.Separator))
+		switch {
+		case isDotFile(path):
+			report.TempFiles = append(report.TempFiles, relPath)
+			return oil.If(de.IsDir(), fs.SkipDir, nil)
+		case de.IsDir():
+			dirs = append(dirs, path)
+			return nil
+		case de.Type().IsRegular():
+			for dir := filepath.Dir(path); len(dir) > len(root) && !nonEmptyDirs[dir]; dir = filepath.Dir(dir) {
+				nonEmptyDirs[dir] = true
+			}
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return nil // the file was probably removed in the mean time
+		}
+		if fi.Mode().IsRegular() && fi.Size() == 0 {
+			report.EmptyFiles = append(report.EmptyFiles, relPath)
+		}
+		return nil
+	})
+	for _, dir := range dirs {
+		if !nonEmptyDirs[dir] {
+			rel, _ := filepath.Rel(b.Root, dir) // can't fail, it did in WalkDir
+			report.EmptyDirs = append(report.EmptyDirs, strings.Split(rel, string(filepath.Separator)))
+		}
+	}
+	return report, oil.If(err != nil, err, finalErr)
+}
+
+// Repair fixes the anomalies of a report returned by Verify: it deletes the
+// temporary files and directories, with their content, then the empty
+// directories.  Empty files are left alone, since empty content can be
+// legitimate.
+// Temporary files are deleted regardless of their age, so Repair mustn't be
+// used while files are written to the Bunch; use CleanGarbage in that case.
+// It doesn't stop at the first error, but returns it after trying everything.
+func (b *Bunch) Repair(report VerifyReport) error {
+	var finalErr error
+	for _, relPath := range report.TempFiles {
+		if err := os.RemoveAll(b.Path(relPath)); err != nil && finalErr == nil {
+			finalErr = err
+		}
+	}
+	// Verify lists directories before their subdirectories, so iterate in
+	// reverse to delete subdirectories first
+	for i := len(report.EmptyDirs) - 1; i >= 0; i-- {
+		if err := os.Remove(b.Path(report.EmptyDirs[i])); err != nil && finalErr == nil {
+			finalErr = err
+		}
+	}
+	return finalErr
+}
+
 // Write creates (or overwrites) a file with the content of a reader, creating all needed subdirectories.
 // The write is done atomically by writing a temporary file and renaming it.
 // The relative path must be valid (see ValidateRelPath).
@@ -1,6 +1,7 @@
 package bunch
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/fs"
@@ -101,6 +102,47 @@ func TestWriteAndPathAndWalk(t *testing.T) {
 	}))
 }
 
+func readRelPaths(t *testing.T, c <-chan []string, n int) []string {
+	result := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		select {
+		case relPath := <-c:
+			result = append(result, strings.Join(relPath, ","))
+		case <-time.After(10 * time.Second):
+			require.FailNow(t, "timeout while waiting for Watch", "got %q", result)
+		}
+	}
+	select {
+	case relPath := <-c:
+		require.FailNow(t, "Watch reported an unexpected file", "%q", relPath)
+	case <-time.After(time.Second / 10):
+	}
+	return result
+}
+
+func TestWatch(t *testing.T) {
+	t.Parallel()
+	for _, rescan := range []time.Duration{0, time.Second / 20} {
+		b, err := NewBunch(t.TempDir(), &Options{WatchRescanInterval: rescan})
+		require.NoError(t, err)
+		require.NoError(t, b.Write([]string{"old", "file"}, strings.NewReader("old")))
+		ctx, cancel := context.WithCancel(context.Background())
+		c, err := b.Watch(ctx)
+		require.NoError(t, err)
+		require.NoError(t, b.Write([]string{"foo"}, strings.NewReader("foo")))
+		require.NoError(t, b.Write([]string{"old", "bar"}, strings.NewReader("bar")))
+		require.NoError(t, os.WriteFile(b.Path([]string{".garbage"}), []byte("garbage"), 0666))
+		require.NoError(t, b.Write([]string{"new", "sub", "baz"}, strings.NewReader("baz")))
+		require.ElementsMatch(t, []string{"foo", "old,bar", "new,sub,baz"}, readRelPaths(t, c, 3), rescan)
+		require.NoError(t, b.Write([]string{"new", "sub", "qux"}, strings.NewReader("qux")))
+		require.Equal(t, []string{"new,sub,qux"}, readRelPaths(t, c, 1), rescan)
+		cancel()
+		for range c {
+		}
+	}
+	require.Error(t, oil.Second((&Bunch{Root: "/noexist/noexist"}).Watch(context.Background())))
+}
+
 func TestTmpFilePath(t *testing.T) {
 	t.Parallel()
 	tmp := t.TempDir()
@@ -144,3 +186,36 @@ func TestValidateRelPath(t *testing.T) {
 		oil.If(tc.valid, require.NoError, require.Error)(t, ValidateRelPath(strings.Split(tc.relPath, ",")), tc)
 	}
 }
+
+func TestVerifyAndRepair(t *testing.T) {
+	t.Parallel()
+	b, err := NewBunch(t.TempDir()+"/", &Options{})
+	require.NoError(t, err)
+	report, err := b.Verify()
+	require.NoError(t, err)
+	require.Equal(t, VerifyReport{}, report)
+
+	require.NoError(t, b.Write([]string{"a", "b", "ok.txt"}, strings.NewReader("hello")))
+	require.NoError(t, b.Write([]string{"a", "empty.txt"}, strings.NewReader("")))
+	require.NoError(t, os.WriteFile(b.Path([]string{"a", "b", ".tmpfoo"}), []byte("x"), 0666))
+	require.NoError(t, os.MkdirAll(b.Path([]string{"c", "d", "e"}), 0777))
+	require.NoError(t, os.WriteFile(b.Path([]string{"c", "d", ".tmpbar"}), []byte("x"), 0666))
+	require.NoError(t, os.MkdirAll(b.Path([]string{".garbage", "f"}), 0777))
+	report, err = b.Verify()
+	require.NoError(t, err)
+	require.Equal(t, VerifyReport{
+		TempFiles:  [][]string{{".garbage"}, {"a", "b", ".tmpfoo"}, {"c", "d", ".tmpbar"}},
+		EmptyFiles: [][]string{{"a", "empty.txt"}},
+		EmptyDirs:  [][]string{{"c"}, {"c", "d"}, {"c", "d", "e"}},
+	}, report)
+
+	require.NoError(t, b.Repair(report))
+	_, err = os.Stat(b.Path([]string{".garbage"}))
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	report, err = b.Verify()
+	require.NoError(t, err)
+	require.Equal(t, VerifyReport{EmptyFiles: [][]string{{"a", "empty.txt"}}}, report)
+	content, err := os.ReadFile(b.Path([]string{"a", "b", "ok.txt"}))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(content))
+}
@@ -4,6 +4,7 @@ go 1.23.4
 
 require (
 	github.com/bcogs/golibs/oil v0.0.0-20250105111226-f2b16a9ccbb8
+	github.com/fsnotify/fsnotify v1.8.0
 	github.com/stretchr/testify v1.10.0
 )
 
@@ -11,5 +12,6 @@ require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/exp v0.0.0-20221211140036-ad323defaf05 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -2,6 +2,11 @@
 package eztime
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,6 +28,10 @@ func MustParse(layout, value string) time.Time {
 	return result
 }
 
+// RFC3339MustParse parses a time in the time.RFC3339Nano format, which also
+// accepts time.RFC3339 times, and panics on parse error.
+func RFC3339MustParse(s string) time.Time { return MustParse(time.RFC3339Nano, s) }
+
 // MustParseInLocation is a wrapper around time.ParseInLocation that panics on error.
 func MustParseInLocation(layout, value string, loc *time.Location) time.Time {
 	result, err := time.ParseInLocation(layout, value, loc)
@@ -41,6 +50,71 @@ func MustParseDuration(s string) time.Duration {
 	return result
 }
 
+// ParseRelativeDuration parses a time relative to now, in English, and returns
+// it, supporting: "N <unit>s ago", "N <unit>s from now" (the s is optional),
+// "last <unit>", and "yesterday", "today" or "tomorrow", which are midnight at
+// the start of those days.  The units are second, minute, hour, day, week,
+// month and year; days and larger units are calendar units, e.g. "1 day ago"
+// is the same time of the day on the previous day even if daylight saving
+// time changed in between.
+// It's case insensitive.
+func ParseRelativeDuration(s string, now time.Time) (time.Time, error) {
+	words := strings.Fields(strings.ToLower(s))
+	switch {
+	case len(words) == 1 && words[0] == "yesterday":
+		return StartOfDay(now).AddDate(0, 0, -1), nil
+	case len(words) == 1 && words[0] == "today":
+		return StartOfDay(now), nil
+	case len(words) == 1 && words[0] == "tomorrow":
+		return StartOfDay(now).AddDate(0, 0, 1), nil
+	case len(words) == 2 && words[0] == "last":
+		if add := addRelative(words[1]); add != nil {
+			return add(now, -1), nil
+		}
+	case len(words) == 3 && words[2] == "ago", len(words) == 4 && words[2] == "from" && words[3] == "now":
+		n, err := strconv.Atoi(words[0])
+		if add := addRelative(strings.TrimSuffix(words[1], "s")); err == nil && add != nil {
+			if words[2] == "ago" {
+				n = -n
+			}
+			return add(now, n), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid relative time %q", s)
+}
+
+// addRelative returns a function adding a number of units to a time, or nil
+// if the unit is unknown.
+func addRelative(unit string) func(t time.Time, n int) time.Time {
+	add := func(d time.Duration) func(time.Time, int) time.Time {
+		return func(t time.Time, n int) time.Time { return t.Add(time.Duration(n) * d) }
+	}
+	switch unit {
+	case "second":
+		return add(time.Second)
+	case "minute":
+		return add(time.Minute)
+	case "hour":
+		return add(time.Hour)
+	case "day":
+		return func(t time.Time, n int) time.Time { return t.AddDate(0, 0, n) }
+	case "week":
+		return func(t time.Time, n int) time.Time { return t.AddDate(0, 0, 7*n) }
+	case "month":
+		return func(t time.Time, n int) time.Time { return t.AddDate(0, n, 0) }
+	case "year":
+		return func(t time.Time, n int) time.Time { return t.AddDate(n, 0, 0) }
+	}
+	return nil
+}
+
+// SleepUntil sleeps until a time, or doesn't sleep at all if it's in the past.
+func SleepUntil(t time.Time) {
+	if d := time.Until(t); d > 0 {
+		time.Sleep(d)
+	}
+}
+
 // CancellableSleep sleeps for a certain duration at least, or until a read from
 // a channel returns something.
 // Usually, the chan is a ctx.Done(), but it doesn't have to be.
@@ -57,3 +131,369 @@ func CancellableSleep[T any](d time.Duration, c <-chan T) T {
 	}
 	return result
 }
+
+// TimeToUnixNano returns the number of nanoseconds elapsed since the Unix epoch.
+func TimeToUnixNano(t time.Time) int64 { return t.UnixNano() }
+
+// UnixNanoToTime returns the local time a number of nanoseconds after the Unix epoch.
+func UnixNanoToTime(ns int64) time.Time { return time.Unix(0, ns) }
+
+// EpochMillis returns the number of milliseconds elapsed since the Unix epoch.
+func EpochMillis(t time.Time) int64 { return t.UnixMilli() }
+
+// FromEpochMillis returns the local time a number of milliseconds after the Unix epoch.
+func FromEpochMillis(ms int64) time.Time { return time.UnixMilli(ms) }
+
+// EpochMicros returns the number of microseconds elapsed since the Unix epoch.
+func EpochMicros(t time.Time) int64 { return t.UnixMicro() }
+
+// FromEpochMicros returns the local time a number of microseconds after the Unix epoch.
+func FromEpochMicros(us int64) time.Time { return time.UnixMicro(us) }
+
+// IsWeekend returns whether a time is on a Saturday or a Sunday, in its location.
+func IsWeekend(t time.Time) bool {
+	wd := t.Weekday()
+	return wd == time.Saturday || wd == time.Sunday
+}
+
+// IsWeekday returns whether a time is on a Monday to Friday, in its location.
+func IsWeekday(t time.Time) bool { return !IsWeekend(t) }
+
+// HolidayCalendar tells which days are holidays, for business days computations.
+type HolidayCalendar interface {
+	// IsHoliday returns whether the day of a time, in a location, is a holiday.
+	IsHoliday(t time.Time, loc *time.Location) bool
+}
+
+// NullHolidayCalendar is a HolidayCalendar without any holiday.
+type NullHolidayCalendar struct{}
+
+// IsHoliday always returns false.
+func (NullHolidayCalendar) IsHoliday(time.Time, *time.Location) bool { return false }
+
+// BusinessDaysBetween returns the number of business days after the day of
+// from and until the day of to included, in a location, or minus the number
+// of such days after the day of to and until the day of from if from is after
+// to.
+// Business days are the Monday to Friday days that aren't holidays in any of
+// the optional HolidayCalendars.
+// Days are calendar days, so it's not fooled by daylight saving time changes.
+func BusinessDaysBetween(from, to time.Time, loc *time.Location, holidays ...HolidayCalendar) int {
+	sign := 1
+	if from.After(to) {
+		from, to, sign = to, from, -1
+	}
+	y, m, d := from.In(loc).Date()
+	n := 0
+	for i := 1; i <= calendarDaysBetween(from, to, loc); i++ {
+		if isBusinessDay(y, m, d+i, loc, holidays) {
+			n++
+		}
+	}
+	return sign * n
+}
+
+// AddBusinessDays returns the same time of the day as a time, in a location,
+// n business days later, or -n business days earlier if n is negative, e.g.
+// without holidays, the next Monday for 1 on Fridays, Saturdays and Sundays,
+// or the previous Friday for -1 on Saturdays, Sundays and Mondays.
+// Business days are the Monday to Friday days that aren't holidays in any of
+// the optional HolidayCalendars.
+// For n >= 0, BusinessDaysBetween(t, AddBusinessDays(t, n, loc, holidays...),
+// loc, holidays...) is n.
+// Days are calendar days, so it's not fooled by daylight saving time changes.
+func AddBusinessDays(t time.Time, n int, loc *time.Location, holidays ...HolidayCalendar) time.Time {
+	t = t.In(loc)
+	y, m, d := t.Date()
+	step := 1
+	if n < 0 {
+		n, step = -n, -1
+	}
+	for n > 0 {
+		if d += step; isBusinessDay(y, m, d, loc, holidays) {
+			n--
+		}
+	}
+	hour, minute, sec := t.Clock()
+	return time.Date(y, m, d, hour, minute, sec, t.Nanosecond(), loc)
+}
+
+// isBusinessDay returns whether a day, which can be out of its month's range
+// like with time.Date, is a business day in a location.
+func isBusinessDay(y int, m time.Month, d int, loc *time.Location, holidays []HolidayCalendar) bool {
+	t := time.Date(y, m, d, 12, 0, 0, 0, loc) // noon is in the right day, even if DST shifts midnight
+	if !IsWeekday(t) {
+		return false
+	}
+	for _, h := range holidays {
+		if h.IsHoliday(t, loc) {
+			return false
+		}
+	}
+	return true
+}
+
+// NextWeekday returns midnight at the start of the first Monday to Friday day
+// after the day of a time, in a location, e.g. the next Monday on Fridays.
+func NextWeekday(t time.Time, loc *time.Location) time.Time {
+	y, m, d := t.In(loc).Date()
+	for i := 1; ; i++ {
+		if IsWeekday(time.Date(y, m, d+i, 12, 0, 0, 0, loc)) {
+			return time.Date(y, m, d+i, 0, 0, 0, 0, loc)
+		}
+	}
+}
+
+// DaysBetween returns the number of calendar days between the days of two
+// times, in the location of from, so it's not fooled by daylight saving time
+// changes; it's negative if from is after to.
+func DaysBetween(from, to time.Time) int { return calendarDaysBetween(from, to, from.Location()) }
+
+// MonthsBetween returns the number of complete calendar months between two
+// times, in the location of from, e.g. 1 from January 15th to February 15th,
+// but 0 from January 31st to February 29th; it's negative if from is after to.
+func MonthsBetween(from, to time.Time) int {
+	if from.After(to) {
+		return -MonthsBetween(to.In(from.Location()), from)
+	}
+	to = to.In(from.Location())
+	y1, m1, d1 := from.Date()
+	y2, m2, d2 := to.Date()
+	months := (y2-y1)*12 + int(m2-m1)
+	// the last month is incomplete if to is earlier in its month than from
+	if d2 < d1 || d2 == d1 && clockDuration(to) < clockDuration(from) {
+		months--
+	}
+	return months
+}
+
+// clockDuration returns the time of the day of a time, as shown by a clock.
+func clockDuration(t time.Time) time.Duration {
+	h, m, s := t.Clock()
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second + time.Duration(t.Nanosecond())
+}
+
+// calendarDaysBetween returns the number of days between the days of two
+// times in a location.
+func calendarDaysBetween(from, to time.Time, loc *time.Location) int {
+	y1, m1, d1 := from.In(loc).Date()
+	y2, m2, d2 := to.In(loc).Date()
+	return int(time.Date(y2, m2, d2, 0, 0, 0, 0, time.UTC).Sub(time.Date(y1, m1, d1, 0, 0, 0, 0, time.UTC)) / (24 * time.Hour))
+}
+
+// StartOfDay returns midnight at the start of the day of a time, in its location.
+func StartOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// EndOfDay returns the last nanosecond of the day of a time, in its location.
+func EndOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location()).Add(-1)
+}
+
+// StartOfMonth returns midnight at the start of the month of a time, in its location.
+func StartOfMonth(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+}
+
+// EndOfMonth returns the last nanosecond of the month of a time, in its location.
+func EndOfMonth(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location()).Add(-1)
+}
+
+// TruncateToLocation is like t.Truncate(d), but rounds down to a multiple of d
+// of the wall clock of a location rather than of UTC, e.g. truncating to 24h
+// gives midnight in that location, and truncating to 1h in India, which is
+// UTC+5:30, gives a time with 0 minutes.
+// The result is in that location.  If daylight saving time makes the rounded
+// down wall clock time ambiguous or nonexistent, it's resolved like time.Date
+// does.
+func TruncateToLocation(t time.Time, d time.Duration, loc *time.Location) time.Time {
+	y, m, day := t.In(loc).Date()
+	hour, minute, sec := t.In(loc).Clock()
+	wall := time.Date(y, m, day, hour, minute, sec, t.Nanosecond(), time.UTC).Truncate(d)
+	y, m, day = wall.Date()
+	hour, minute, sec = wall.Clock()
+	return time.Date(y, m, day, hour, minute, sec, wall.Nanosecond(), loc)
+}
+
+// StartOfWeek returns midnight at the start of the week of a time, in its
+// location, for weeks starting on a given day, e.g. time.Monday as in ISO 8601
+// or time.Sunday as in the US.
+func StartOfWeek(t time.Time, firstDay time.Weekday) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d-(int(t.Weekday()-firstDay)+7)%7, 0, 0, 0, 0, t.Location())
+}
+
+// EndOfWeek returns the last nanosecond of the week of a time, in its
+// location, for weeks starting on a given day.
+func EndOfWeek(t time.Time, firstDay time.Weekday) time.Time {
+	y, m, d := StartOfWeek(t, firstDay).Date()
+	return time.Date(y, m, d+7, 0, 0, 0, 0, t.Location()).Add(-1)
+}
+
+// ISOWeekNumber returns the ISO 8601 year and week number of a time, like
+// t.ISOWeek(); the year can differ from t.Year() in early January and late
+// December.
+func ISOWeekNumber(t time.Time) (year, week int) { return t.ISOWeek() }
+
+// ISOWeekStart returns midnight on the Monday starting an ISO 8601 week, in a
+// location.
+func ISOWeekStart(year, week int, loc *time.Location) time.Time {
+	jan4 := time.Date(year, 1, 4, 12, 0, 0, 0, loc) // January 4th is always in week 1
+	return time.Date(year, 1, 4-(int(jan4.Weekday())+6)%7+7*(week-1), 0, 0, 0, 0, loc)
+}
+
+// StartOfQuarter returns midnight at the start of the quarter of a time, i.e.
+// on January, April, July or October 1st, in its location.
+func StartOfQuarter(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return time.Date(y, m-(m-1)%3, 1, 0, 0, 0, 0, t.Location())
+}
+
+// EndOfQuarter returns the last nanosecond of the quarter of a time, in its location.
+func EndOfQuarter(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return time.Date(y, m-(m-1)%3+3, 1, 0, 0, 0, 0, t.Location()).Add(-1)
+}
+
+// FormatAge describes how far a time is from now, in a human readable way, e.g.
+// "just now" (less than 10s away), "3 minutes ago", "2 days ago" or "in 5
+// minutes", with the largest unit that fits, rounding down.
+// Months are 30 days, and years 365 days.
+func FormatAge(t, now time.Time) string {
+	d := now.Sub(t)
+	format := "%d %s ago"
+	if d < 0 {
+		d, format = -d, "in %d %s"
+	}
+	if d < 10*time.Second {
+		return "just now"
+	}
+	const day = 24 * time.Hour
+	for _, unit := range []struct {
+		d    time.Duration
+		name string
+	}{{365 * day, "year"}, {30 * day, "month"}, {day, "day"}, {time.Hour, "hour"}, {time.Minute, "minute"}} {
+		if n := d / unit.d; n > 1 {
+			return fmt.Sprintf(format, n, unit.name+"s")
+		} else if n == 1 {
+			return fmt.Sprintf(format, n, unit.name)
+		}
+	}
+	return fmt.Sprintf(format, d/time.Second, "seconds")
+}
+
+// FormatTimeSince is FormatAge(t, time.Now()).
+func FormatTimeSince(t time.Time) string { return FormatAge(t, time.Now()) }
+
+// FormatDuration formats a duration in a human readable way, e.g. "2h 5m 3s"
+// or "-450ms": zero components are omitted, and the duration is rounded to the
+// millisecond, unless it's less than 1ms, e.g. "12µs".
+func FormatDuration(d time.Duration) string { return formatDuration(d, " ", false) }
+
+// FormatDurationShort formats a duration compactly, e.g. "2h5m3s", "3d" or
+// "-450ms": like FormatDuration, but without spaces, and with days ("d") for
+// durations of 24 hours or more.
+func FormatDurationShort(d time.Duration) string { return formatDuration(d, "", true) }
+
+// formatDuration implements FormatDuration and FormatDurationShort, with an
+// arbitrary separator between the components, and optionally days.
+func formatDuration(d time.Duration, sep string, days bool) string {
+	sign, ns := "", uint64(d)
+	if d < 0 {
+		sign, ns = "-", uint64(-(d+1))+1 // -d overflows for the min duration
+	}
+	if ns < uint64(time.Millisecond) {
+		return sign + time.Duration(ns).String()
+	}
+	ms := (ns + uint64(time.Millisecond)/2) / uint64(time.Millisecond)
+	type unit struct {
+		ms   uint64
+		name string
+	}
+	units := []unit{{3600000, "h"}, {60000, "m"}, {1000, "s"}, {1, "ms"}}
+	if days {
+		units = append([]unit{{24 * 3600000, "d"}}, units...)
+	}
+	var parts []string
+	for _, unit := range units {
+		if n := ms / unit.ms; n > 0 {
+			parts = append(parts, strconv.FormatUint(n, 10)+unit.name)
+			ms -= n * unit.ms
+		}
+	}
+	return sign + strings.Join(parts, sep)
+}
+
+// ErrCancelled is returned by CancellableSleepWithError when it's cancelled.
+var ErrCancelled = errors.New("sleep cancelled")
+
+// CancellableSleepWithError sleeps for a certain duration at least, and
+// returns nil, or returns ErrCancelled as soon as a read from a channel
+// returns, typically because it's closed, e.g. a ctx.Done().
+func CancellableSleepWithError(d time.Duration, cancel <-chan struct{}) error {
+	if d < 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-cancel:
+		return ErrCancelled
+	}
+}
+
+// DateIterator iterates over times at regular intervals, e.g.:
+//
+//	for it := eztime.NewDateIterator(start, end, time.Hour); it.Next(); {
+//		process(it.Value())
+//	}
+type DateIterator struct {
+	value, to time.Time
+	step      time.Duration
+	started   bool
+}
+
+// NewDateIterator creates a DateIterator over the times from a time included
+// to another excluded, by increments of a step, which must be positive,
+// otherwise nothing is iterated over.
+// Note a step of 24h isn't always a day when daylight saving time changes.
+func NewDateIterator(from, to time.Time, step time.Duration) *DateIterator {
+	return &DateIterator{value: from, to: to, step: step}
+}
+
+// Next moves to the next time, and returns whether there's one.
+func (it *DateIterator) Next() bool {
+	if it.step <= 0 {
+		return false
+	}
+	if it.started {
+		it.value = it.value.Add(it.step)
+	}
+	it.started = true
+	return it.value.Before(it.to)
+}
+
+// Value returns the current time; Next must have returned true before.
+func (it *DateIterator) Value() time.Time { return it.value }
+
+// NewCancellableSleepChan returns a channel that's closed after a duration,
+// to be used in a select, and a function that closes it early, which can be
+// called several times, and releases the associated timer.
+func NewCancellableSleepChan(d time.Duration) (<-chan struct{}, func()) {
+	c := make(chan struct{})
+	var once sync.Once
+	closeC := func() { once.Do(func() { close(c) }) }
+	timer := time.AfterFunc(d, closeC)
+	return c, func() {
+		timer.Stop()
+		closeC()
+	}
+}
@@ -1,6 +1,7 @@
 package eztime
 
 import (
+	"math"
 	"testing"
 	"time"
 
@@ -24,6 +25,11 @@ func TestMustFunctions(t *testing.T) {
 	assert.Panics(t, func() { MustParseInLocation(layout, "invalid", chicago) })
 	assert.Panics(t, func() { MustParseInLocation("invalid", prettyTime, chicago) })
 
+	t0 = RFC3339MustParse("2024-03-31T12:00:00.123456789+02:00")
+	assert.True(t, t0.Equal(time.Date(2024, 3, 31, 10, 0, 0, 123456789, time.UTC)))
+	assert.True(t, RFC3339MustParse("2024-03-31T12:00:00Z").Equal(time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)))
+	assert.Panics(t, func() { RFC3339MustParse("2024-03-31 12:00:00") })
+
 	d := MustParseDuration("1h5m")
 	assert.Equal(t, time.Hour+5*time.Minute, d)
 	assert.Panics(t, func() { MustParseDuration("invalid") })
@@ -43,3 +49,419 @@ func TestCancellableSleep(t *testing.T) {
 	assert.Equal(t, 3, CancellableSleep(time.Hour, c))
 	assert.Less(t, time.Now().Sub(t2), time.Second)
 }
+
+func TestFormatDuration(t *testing.T) {
+	t.Parallel()
+	for d, s := range map[time.Duration]string{
+		0:                         "0s",
+		12 * time.Nanosecond:      "12ns",
+		-450 * time.Microsecond:   "-450µs",
+		time.Millisecond:          "1ms",
+		450 * time.Millisecond:    "450ms",
+		1499999 * time.Nanosecond: "1ms",
+		1500000 * time.Nanosecond: "2ms",
+		2*time.Hour + 5*time.Minute + 3*time.Second + 1: "2h 5m 3s",
+		-(time.Minute + 1500*time.Millisecond):          "-1m 1s 500ms",
+		999999999 * time.Nanosecond:                     "1s",
+		49 * time.Hour:                                  "49h",
+		time.Duration(math.MinInt64):                    "-2562047h 47m 16s 855ms",
+	} {
+		assert.Equal(t, s, FormatDuration(d), "%d", d)
+	}
+}
+
+func TestIsWeekendWeekday(t *testing.T) {
+	t.Parallel()
+	friday := time.Date(2024, 3, 29, 23, 30, 0, 0, time.UTC)
+	for i, weekend := range []bool{false, true, true, false, false, false, false, false, true} {
+		day := friday.AddDate(0, 0, i)
+		assert.Equal(t, weekend, IsWeekend(day), "%v", day)
+		assert.Equal(t, !weekend, IsWeekday(day), "%v", day)
+	}
+	assert.True(t, IsWeekend(friday.In(MustLoadLocation("Asia/Tokyo"))))
+}
+
+func TestStartEndOfDayMonth(t *testing.T) {
+	t.Parallel()
+	paris := MustLoadLocation("Europe/Paris")
+	const layout = "2006-01-02 15:04:05.999999999 -0700"
+	for _, tc := range []struct{ t, startOfDay, endOfDay, startOfMonth, endOfMonth string }{
+		{"2024-02-10 13:14:15.5 +0100", "2024-02-10 00:00:00 +0100", "2024-02-10 23:59:59.999999999 +0100", "2024-02-01 00:00:00 +0100", "2024-02-29 23:59:59.999999999 +0100"},
+		{"2023-02-28 00:00:00 +0100", "2023-02-28 00:00:00 +0100", "2023-02-28 23:59:59.999999999 +0100", "2023-02-01 00:00:00 +0100", "2023-02-28 23:59:59.999999999 +0100"},
+		// daylight saving time starts on March 31st 2024 at 2am
+		{"2024-03-31 23:59:59 +0200", "2024-03-31 00:00:00 +0100", "2024-03-31 23:59:59.999999999 +0200", "2024-03-01 00:00:00 +0100", "2024-03-31 23:59:59.999999999 +0200"},
+		{"2024-12-31 12:00:00 +0100", "2024-12-31 00:00:00 +0100", "2024-12-31 23:59:59.999999999 +0100", "2024-12-01 00:00:00 +0100", "2024-12-31 23:59:59.999999999 +0100"},
+	} {
+		t0 := MustParseInLocation(layout, tc.t, paris)
+		for _, x := range []struct {
+			f    func(time.Time) time.Time
+			want string
+		}{{StartOfDay, tc.startOfDay}, {EndOfDay, tc.endOfDay}, {StartOfMonth, tc.startOfMonth}, {EndOfMonth, tc.endOfMonth}} {
+			got := x.f(t0)
+			assert.Equal(t, x.want, got.Format(layout), tc.t)
+			assert.Equal(t, paris, got.Location())
+		}
+	}
+}
+
+func TestBusinessDaysBetween(t *testing.T) {
+	t.Parallel()
+	paris := MustLoadLocation("Europe/Paris")
+	date := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2024, month, day, hour, 0, 0, 0, paris)
+	}
+	friday, saturday, sunday, monday := date(3, 29, 10), date(3, 30, 10), date(3, 31, 10), date(4, 1, 10)
+	assert.Equal(t, 0, BusinessDaysBetween(friday, friday, paris))
+	assert.Equal(t, 0, BusinessDaysBetween(friday, date(3, 29, 23), paris))
+	assert.Equal(t, 0, BusinessDaysBetween(friday, saturday, paris))
+	assert.Equal(t, 0, BusinessDaysBetween(friday, sunday, paris)) // DST starts on that sunday
+	assert.Equal(t, 1, BusinessDaysBetween(friday, monday, paris))
+	assert.Equal(t, -1, BusinessDaysBetween(monday, friday, paris))
+	assert.Equal(t, 1, BusinessDaysBetween(saturday, monday, paris))
+	assert.Equal(t, 4, BusinessDaysBetween(date(3, 25, 0), friday, paris)) // from is excluded
+	assert.Equal(t, 5, BusinessDaysBetween(date(3, 24, 23), friday, paris))
+	assert.Equal(t, 21, BusinessDaysBetween(date(2, 29, 12), date(3, 31, 12), paris))
+	assert.Equal(t, -21, BusinessDaysBetween(date(3, 31, 12), date(2, 29, 12), paris))
+	// 11pm on friday in Paris is on saturday in Tokyo
+	assert.Equal(t, 1, BusinessDaysBetween(date(3, 28, 12), date(3, 29, 23), paris))
+	assert.Equal(t, 0, BusinessDaysBetween(date(3, 28, 20), date(3, 29, 23), MustLoadLocation("Asia/Tokyo")))
+}
+
+func TestNextWeekday(t *testing.T) {
+	t.Parallel()
+	paris, tokyo := MustLoadLocation("Europe/Paris"), MustLoadLocation("Asia/Tokyo")
+	for _, tc := range []struct {
+		t, want string
+		loc     *time.Location
+	}{
+		{"2024-03-27T10:00:00+01:00", "2024-03-28T00:00:00+01:00", paris}, // wednesday
+		{"2024-03-29T00:00:00+01:00", "2024-04-01T00:00:00+02:00", paris}, // friday, DST starts on sunday
+		{"2024-03-30T23:59:59+01:00", "2024-04-01T00:00:00+02:00", paris},
+		{"2024-03-31T12:00:00+02:00", "2024-04-01T00:00:00+02:00", paris},
+		{"2024-03-28T20:00:00+01:00", "2024-04-01T00:00:00+09:00", tokyo}, // friday in Tokyo
+	} {
+		got := NextWeekday(MustParse(time.RFC3339, tc.t), tc.loc)
+		assert.Equal(t, tc.want, got.Format(time.RFC3339), tc.t)
+		assert.Equal(t, tc.loc, got.Location())
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	for d, s := range map[time.Duration]string{
+		0:                                 "just now",
+		9 * time.Second:                   "just now",
+		-9 * time.Second:                  "just now",
+		10 * time.Second:                  "10 seconds ago",
+		-59 * time.Second:                 "in 59 seconds",
+		time.Minute:                       "1 minute ago",
+		3*time.Minute + 59*time.Second:    "3 minutes ago",
+		-5 * time.Minute:                  "in 5 minutes",
+		time.Hour + 59*time.Minute:        "1 hour ago",
+		49 * time.Hour:                    "2 days ago",
+		29 * 24 * time.Hour:               "29 days ago",
+		-61 * 24 * time.Hour:              "in 2 months",
+		364 * 24 * time.Hour:              "12 months ago",
+		3 * 365 * 24 * time.Hour:          "3 years ago",
+		-(365*24*time.Hour + time.Second): "in 1 year",
+	} {
+		assert.Equal(t, s, FormatAge(now.Add(-d), now), "%v", d)
+	}
+	assert.Equal(t, "just now", FormatTimeSince(time.Now()))
+	assert.Equal(t, "2 hours ago", FormatTimeSince(time.Now().Add(-150*time.Minute)))
+}
+
+func TestEpoch(t *testing.T) {
+	t.Parallel()
+	t0 := time.Date(2024, 3, 31, 12, 0, 0, 123456789, time.UTC)
+	assert.Equal(t, int64(1711886400123), EpochMillis(t0))
+	assert.Equal(t, int64(1711886400123456), EpochMicros(t0))
+	assert.True(t, t0.Truncate(time.Millisecond).Equal(FromEpochMillis(1711886400123)))
+	assert.True(t, t0.Truncate(time.Microsecond).Equal(FromEpochMicros(1711886400123456)))
+	assert.Equal(t, int64(-1), EpochMillis(FromEpochMillis(-1)))
+	assert.Equal(t, time.Unix(0, 0), FromEpochMicros(0))
+	assert.Equal(t, int64(1711886400123456789), TimeToUnixNano(t0))
+	assert.True(t, t0.Equal(UnixNanoToTime(1711886400123456789)))
+}
+
+func TestSleepUntil(t *testing.T) {
+	t0 := time.Now()
+	SleepUntil(t0.Add(-time.Hour))
+	t1 := time.Now()
+	assert.Less(t, t1.Sub(t0), time.Second)
+	SleepUntil(t1.Add(time.Second / 10))
+	t2 := time.Now()
+	assert.Less(t, t2.Sub(t1), time.Second)
+	assert.GreaterOrEqual(t, t2.Sub(t1), time.Second/10)
+}
+
+func TestStartEndOfWeekQuarter(t *testing.T) {
+	t.Parallel()
+	paris := MustLoadLocation("Europe/Paris")
+	const layout = "2006-01-02 15:04:05.999999999 -0700"
+	for _, tc := range []struct {
+		t                                                    string
+		firstDay                                             time.Weekday
+		startOfWeek, endOfWeek, startOfQuarter, endOfQuarter string
+	}{
+		// sunday, daylight saving time starts at 2am
+		{"2024-03-31 12:00:00 +0200", time.Monday, "2024-03-25 00:00:00 +0100", "2024-03-31 23:59:59.999999999 +0200", "2024-01-01 00:00:00 +0100", "2024-03-31 23:59:59.999999999 +0200"},
+		{"2024-03-31 12:00:00 +0200", time.Sunday, "2024-03-31 00:00:00 +0100", "2024-04-06 23:59:59.999999999 +0200", "2024-01-01 00:00:00 +0100", "2024-03-31 23:59:59.999999999 +0200"},
+		{"2024-04-01 00:00:00 +0200", time.Monday, "2024-04-01 00:00:00 +0200", "2024-04-07 23:59:59.999999999 +0200", "2024-04-01 00:00:00 +0200", "2024-06-30 23:59:59.999999999 +0200"},
+		{"2024-12-31 23:00:00 +0100", time.Saturday, "2024-12-28 00:00:00 +0100", "2025-01-03 23:59:59.999999999 +0100", "2024-10-01 00:00:00 +0200", "2024-12-31 23:59:59.999999999 +0100"},
+		{"2024-08-15 08:00:00 +0200", time.Thursday, "2024-08-15 00:00:00 +0200", "2024-08-21 23:59:59.999999999 +0200", "2024-07-01 00:00:00 +0200", "2024-09-30 23:59:59.999999999 +0200"},
+	} {
+		t0 := MustParseInLocation(layout, tc.t, paris)
+		assert.Equal(t, tc.startOfWeek, StartOfWeek(t0, tc.firstDay).Format(layout), tc.t)
+		assert.Equal(t, tc.endOfWeek, EndOfWeek(t0, tc.firstDay).Format(layout), tc.t)
+		assert.Equal(t, tc.startOfQuarter, StartOfQuarter(t0).Format(layout), tc.t)
+		assert.Equal(t, tc.endOfQuarter, EndOfQuarter(t0).Format(layout), tc.t)
+		assert.Equal(t, paris, StartOfWeek(t0, tc.firstDay).Location())
+	}
+}
+
+func TestDaysMonthsBetween(t *testing.T) {
+	t.Parallel()
+	paris, tokyo := MustLoadLocation("Europe/Paris"), MustLoadLocation("Asia/Tokyo")
+	date := func(y int, m time.Month, d, h int, loc *time.Location) time.Time {
+		return time.Date(y, m, d, h, 0, 0, 0, loc)
+	}
+	for _, tc := range []struct {
+		from, to     time.Time
+		days, months int
+	}{
+		{date(2024, 3, 30, 23, paris), date(2024, 3, 31, 23, paris), 1, 0}, // 23 hours, DST starts
+		{date(2024, 3, 30, 1, paris), date(2024, 3, 30, 23, paris), 0, 0},
+		{date(2024, 3, 30, 23, paris), date(2024, 3, 30, 23, tokyo), 0, 0}, // 3pm in Paris
+		{date(2024, 3, 30, 12, paris), date(2024, 3, 31, 1, tokyo), 0, 0},  // 5pm on the 30th in Paris
+		{date(2024, 1, 15, 12, paris), date(2024, 2, 15, 12, paris), 31, 1},
+		{date(2024, 1, 15, 12, paris), date(2024, 2, 15, 11, paris), 31, 0},
+		{date(2024, 1, 31, 0, paris), date(2024, 2, 29, 0, paris), 29, 0},
+		{date(2024, 1, 31, 0, paris), date(2024, 3, 31, 0, paris), 60, 2},
+		{date(2023, 5, 10, 0, paris), date(2024, 5, 9, 0, paris), 365, 11},
+		{date(2023, 5, 10, 0, paris), date(2024, 5, 10, 0, paris), 366, 12},
+	} {
+		assert.Equal(t, tc.days, DaysBetween(tc.from, tc.to), "%v %v", tc.from, tc.to)
+		assert.Equal(t, tc.months, MonthsBetween(tc.from, tc.to), "%v %v", tc.from, tc.to)
+		if tc.from.Location() == tc.to.Location() {
+			assert.Equal(t, -tc.days, DaysBetween(tc.to, tc.from), "%v %v", tc.to, tc.from)
+			assert.Equal(t, -tc.months, MonthsBetween(tc.to, tc.from), "%v %v", tc.to, tc.from)
+		}
+	}
+}
+
+func TestISOWeek(t *testing.T) {
+	t.Parallel()
+	paris := MustLoadLocation("Europe/Paris")
+	for _, tc := range []struct {
+		t          time.Time
+		year, week int
+		start      string
+	}{
+		{time.Date(2024, 3, 31, 12, 0, 0, 0, paris), 2024, 13, "2024-03-25T00:00:00+01:00"},
+		{time.Date(2024, 4, 1, 0, 0, 0, 0, paris), 2024, 14, "2024-04-01T00:00:00+02:00"},
+		{time.Date(2021, 1, 3, 0, 0, 0, 0, paris), 2020, 53, "2020-12-28T00:00:00+01:00"},
+		{time.Date(2024, 12, 30, 0, 0, 0, 0, paris), 2025, 1, "2024-12-30T00:00:00+01:00"},
+		{time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), 2026, 1, "2025-12-29T00:00:00Z"},
+	} {
+		year, week := ISOWeekNumber(tc.t)
+		assert.Equal(t, []int{tc.year, tc.week}, []int{year, week}, "%v", tc.t)
+		start := ISOWeekStart(year, week, tc.t.Location())
+		assert.Equal(t, tc.start, start.Format(time.RFC3339), "%v", tc.t)
+		assert.Equal(t, StartOfWeek(tc.t, time.Monday), start)
+	}
+}
+
+func TestParseRelativeDuration(t *testing.T) {
+	t.Parallel()
+	paris := MustLoadLocation("Europe/Paris")
+	now := time.Date(2024, 3, 31, 12, 30, 0, 0, paris) // daylight saving time started at 2am
+	for s, want := range map[string]time.Time{
+		"30 seconds ago":      now.Add(-30 * time.Second),
+		"1 second from now":   now.Add(time.Second),
+		"5 Minutes ago":       now.Add(-5 * time.Minute),
+		"13 hours ago":        time.Date(2024, 3, 30, 22, 30, 0, 0, paris),
+		"2 days ago":          time.Date(2024, 3, 29, 12, 30, 0, 0, paris),
+		"1 day ago":           time.Date(2024, 3, 30, 12, 30, 0, 0, paris),
+		"3 days from now":     time.Date(2024, 4, 3, 12, 30, 0, 0, paris),
+		"2 weeks ago":         time.Date(2024, 3, 17, 12, 30, 0, 0, paris),
+		"1 month ago":         time.Date(2024, 2, 31, 12, 30, 0, 0, paris),
+		"2 years from now":    time.Date(2026, 3, 31, 12, 30, 0, 0, paris),
+		"0 days ago":          now,
+		"last week":           time.Date(2024, 3, 24, 12, 30, 0, 0, paris),
+		"last hour":           now.Add(-time.Hour),
+		"yesterday":           time.Date(2024, 3, 30, 0, 0, 0, 0, paris),
+		"  today ":            time.Date(2024, 3, 31, 0, 0, 0, 0, paris),
+		"TOMORROW":            time.Date(2024, 4, 1, 0, 0, 0, 0, paris),
+		"10 minutes from now": now.Add(10 * time.Minute),
+	} {
+		got, err := ParseRelativeDuration(s, now)
+		assert.NoError(t, err, s)
+		assert.Equal(t, want, got, s)
+	}
+	for _, s := range []string{"", "now", "2 days", "two days ago", "2 fortnights ago", "last", "last days ago", "2 days from", "3 days before now"} {
+		_, err := ParseRelativeDuration(s, now)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestCancellableSleepWithError(t *testing.T) {
+	t0 := time.Now()
+	assert.NoError(t, CancellableSleepWithError(-time.Hour, make(chan struct{})))
+	assert.NoError(t, CancellableSleepWithError(time.Second/10, make(chan struct{})))
+	t1 := time.Now()
+	assert.Less(t, t1.Sub(t0), time.Second)
+	assert.Greater(t, t1.Sub(t0), time.Second/20)
+	c := make(chan struct{})
+	close(c)
+	assert.ErrorIs(t, CancellableSleepWithError(time.Hour, c), ErrCancelled)
+	c = make(chan struct{}, 1)
+	c <- struct{}{}
+	assert.ErrorIs(t, CancellableSleepWithError(time.Hour, c), ErrCancelled)
+	assert.Less(t, time.Now().Sub(t1), time.Second)
+}
+
+func TestTruncateToLocation(t *testing.T) {
+	t.Parallel()
+	newYork, kolkata := MustLoadLocation("America/New_York"), MustLoadLocation("Asia/Kolkata")
+	t0 := time.Date(2024, 3, 10, 1, 45, 30, 500, newYork) // daylight saving time starts at 2am
+	for _, tc := range []struct {
+		d    time.Duration
+		loc  *time.Location
+		want string
+	}{
+		{24 * time.Hour, newYork, "2024-03-10T00:00:00-05:00"},
+		{24 * time.Hour, time.UTC, "2024-03-10T00:00:00Z"},
+		{24 * time.Hour, kolkata, "2024-03-10T00:00:00+05:30"},
+		{time.Hour, newYork, "2024-03-10T01:00:00-05:00"},
+		{time.Hour, kolkata, "2024-03-10T12:00:00+05:30"},
+		{15 * time.Minute, kolkata, "2024-03-10T12:15:00+05:30"},
+		{time.Second, newYork, "2024-03-10T01:45:30-05:00"},
+		{0, newYork, "2024-03-10T01:45:30.0000005-05:00"},
+	} {
+		got := TruncateToLocation(t0, tc.d, tc.loc)
+		assert.Equal(t, tc.want, got.Format(time.RFC3339Nano), "%v %v", tc.d, tc.loc)
+		assert.Equal(t, tc.loc, got.Location())
+	}
+	assert.Equal(t, "2024-03-10T00:00:00-05:00", TruncateToLocation(t0.Add(2*time.Hour), 24*time.Hour, newYork).Format(time.RFC3339))
+}
+
+func TestDateIterator(t *testing.T) {
+	t.Parallel()
+	t0 := time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC)
+	collect := func(it *DateIterator) []time.Time {
+		result := []time.Time{}
+		for it.Next() {
+			result = append(result, it.Value())
+		}
+		assert.False(t, it.Next())
+		return result
+	}
+	assert.Equal(t, []time.Time{t0, t0.Add(time.Hour), t0.Add(2 * time.Hour)}, collect(NewDateIterator(t0, t0.Add(3*time.Hour), time.Hour)))
+	assert.Equal(t, []time.Time{t0, t0.Add(time.Hour), t0.Add(2 * time.Hour)}, collect(NewDateIterator(t0, t0.Add(150*time.Minute), time.Hour)))
+	assert.Equal(t, []time.Time{t0}, collect(NewDateIterator(t0, t0.Add(1), 24*time.Hour)))
+	assert.Equal(t, []time.Time{}, collect(NewDateIterator(t0, t0, time.Hour)))
+	assert.Equal(t, []time.Time{}, collect(NewDateIterator(t0, t0.Add(-time.Hour), time.Hour)))
+	assert.Equal(t, []time.Time{}, collect(NewDateIterator(t0, t0.Add(time.Hour), 0)))
+	assert.Equal(t, []time.Time{}, collect(NewDateIterator(t0.Add(time.Hour), t0, -time.Minute)))
+}
+
+func TestAddBusinessDays(t *testing.T) {
+	t.Parallel()
+	paris := MustLoadLocation("Europe/Paris")
+	date := func(month time.Month, day int) time.Time { return time.Date(2024, month, day, 10, 30, 0, 0, paris) }
+	thursday, friday, saturday, sunday, monday := date(3, 28), date(3, 29), date(3, 30), date(3, 31), date(4, 1) // DST starts on sunday
+	for _, tc := range []struct {
+		t, want time.Time
+		n       int
+	}{
+		{friday, friday, 0},
+		{saturday, saturday, 0},
+		{thursday, friday, 1},
+		{friday, monday, 1},
+		{saturday, monday, 1},
+		{sunday, monday, 1},
+		{monday, friday, -1},
+		{sunday, friday, -1},
+		{saturday, thursday, -2},
+		{friday, date(4, 5), 5},
+		{friday, date(3, 22), -5},
+		{date(2, 29), date(4, 1), 22},
+	} {
+		got := AddBusinessDays(tc.t, tc.n, paris)
+		assert.Equal(t, tc.want, got, "%v %d", tc.t, tc.n)
+		if tc.n >= 0 {
+			assert.Equal(t, tc.n, BusinessDaysBetween(tc.t, got, paris), "%v %d", tc.t, tc.n)
+		}
+	}
+	// 11pm on friday in Paris is 7am on saturday in Tokyo
+	got := AddBusinessDays(time.Date(2024, 3, 29, 23, 0, 0, 0, paris), 1, MustLoadLocation("Asia/Tokyo"))
+	assert.Equal(t, "2024-04-01T07:00:00+09:00", got.Format(time.RFC3339))
+}
+
+// dateHolidays is a HolidayCalendar of "YYYY-MM-DD" dates.
+type dateHolidays map[string]bool
+
+func (dh dateHolidays) IsHoliday(t time.Time, loc *time.Location) bool {
+	return dh[t.In(loc).Format("2006-01-02")]
+}
+
+func TestHolidayCalendar(t *testing.T) {
+	t.Parallel()
+	paris := MustLoadLocation("Europe/Paris")
+	date := func(month time.Month, day int) time.Time { return time.Date(2024, month, day, 10, 30, 0, 0, paris) }
+	easter := dateHolidays{"2024-03-29": true, "2024-04-01": true} // good friday and easter monday
+	assert.Equal(t, 5, BusinessDaysBetween(date(3, 24), date(3, 31), paris))
+	assert.Equal(t, 5, BusinessDaysBetween(date(3, 24), date(3, 31), paris, NullHolidayCalendar{}))
+	assert.Equal(t, 4, BusinessDaysBetween(date(3, 24), date(3, 31), paris, easter))
+	assert.Equal(t, -8, BusinessDaysBetween(date(4, 7), date(3, 24), paris, NullHolidayCalendar{}, easter))
+	assert.Equal(t, 3, BusinessDaysBetween(date(3, 24), date(3, 31), paris, easter, dateHolidays{"2024-03-25": true}))
+
+	assert.Equal(t, date(4, 2), AddBusinessDays(date(3, 28), 1, paris, easter))
+	assert.Equal(t, date(3, 28), AddBusinessDays(date(4, 2), -1, paris, easter))
+	assert.Equal(t, date(3, 29), AddBusinessDays(date(3, 28), 1, paris, NullHolidayCalendar{}))
+	assert.Equal(t, date(4, 9), AddBusinessDays(date(3, 28), 6, paris, easter))
+	assert.Equal(t, 6, BusinessDaysBetween(date(3, 28), date(4, 9), paris, easter))
+}
+
+func TestNewCancellableSleepChan(t *testing.T) {
+	t0 := time.Now()
+	c, cancel := NewCancellableSleepChan(time.Second / 10)
+	select {
+	case <-c:
+		t.Error("the channel was closed too early")
+	default:
+	}
+	<-c
+	t1 := time.Now()
+	assert.Greater(t, t1.Sub(t0), time.Second/20)
+	assert.Less(t, t1.Sub(t0), time.Second)
+	cancel()
+
+	c, cancel = NewCancellableSleepChan(time.Hour)
+	cancel()
+	cancel()
+	<-c
+	c, _ = NewCancellableSleepChan(-time.Hour)
+	<-c
+	assert.Less(t, time.Now().Sub(t1), time.Second)
+}
+
+func TestFormatDurationShort(t *testing.T) {
+	t.Parallel()
+	for d, s := range map[time.Duration]string{
+		0:                       "0s",
+		12 * time.Nanosecond:    "12ns",
+		45 * time.Millisecond:   "45ms",
+		-450 * time.Millisecond: "-450ms",
+		2*time.Hour + 5*time.Minute + 3*time.Second + 1: "2h5m3s",
+		72 * time.Hour:                       "3d",
+		49*time.Hour + 1500*time.Millisecond: "2d1h1s500ms",
+		-(24*time.Hour + time.Minute):        "-1d1m",
+		23*time.Hour + 59*time.Minute:        "23h59m",
+		time.Duration(math.MaxInt64):         "106751d23h47m16s855ms",
+	} {
+		assert.Equal(t, s, FormatDurationShort(d), "%d", d)
+	}
+}
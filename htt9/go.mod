@@ -5,11 +5,13 @@ go 1.23.4
 require (
 	github.com/bcogs/golibs/oil v0.0.0-20241230094902-5622b6274d2c
 	github.com/stretchr/testify v1.10.0
+	golang.org/x/net v0.33.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/exp v0.0.0-20221211140036-ad323defaf05 // indirect
+	golang.org/x/text v0.21.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
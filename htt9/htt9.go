@@ -13,15 +13,25 @@ package htt9
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bcogs/golibs/oil"
+	"golang.org/x/net/http2"
 )
 
 // DefaultTimeout is the default client timeout for requests (each retry can use a full timeout).
@@ -30,6 +40,10 @@ const DefaultTimeout = time.Minute
 // ResponseInterpreter is a function called to interpret an HTTP response and decide if it's a success or error, and whether that error is retryable.
 // If it returns a nil error or an error and false, the response is final,
 // otherwise, the request will be retried if the retry max wasn't reached yet.
+// To retry with a different request, e.g. with a refreshed authentication
+// token after a 401, it can modify r.Req, or replace it with a new request,
+// which is what the next attempt sends; r.Req's Body is reset to the Query
+// Body before each attempt though.
 // Check the code of DefaultInterpretResponse for an example of how to create your own function.
 type ResponseInterpreter func(r *Result /* r.Err is nil */, retriesLeft uint) (err error, retryable bool)
 
@@ -45,8 +59,11 @@ type Result struct {
 }
 
 // Query provides simple one line HTTP operations with sane defaults, and allows customizations for advanced needs.
+// Do can be called concurrently for the same Query, as long as it isn't
+// modified, but DoWithJSON modifies it; use Clone to get independent copies,
+// e.g. of a template Query.
 type Query struct {
-	URL          string
+	URL          string            // if it's a path starting with "/" and Client.BaseURL is set, it's joined onto it
 	Body         []byte            // optional
 	ExtraHeaders map[string]string // headers to Add() to the http.Request (note net/http sends a few headers by default)
 
@@ -55,43 +72,81 @@ type Query struct {
 	// the default is DefaultInterpretResponse: it checks the response is a 2xx, and otherwise generates a detailed error
 	InterpretResponse ResponseInterpreter
 
+	// if true, an Expect: 100-continue header is sent, so servers that honor
+	// it can reject the query before the Body is sent, which is useful for
+	// large uploads; net/http handles the mechanics, waiting for the server's
+	// go-ahead for up to the ExpectContinueTimeout of the Transport (1s with
+	// the default Transport, see Client.WithExpectContinueTimeout)
+	ExpectContinue bool
+
+	// optional hooks called at each step of each attempt, e.g. DNS lookup,
+	// connection, or first response byte, to break down latency
+	Trace *httptrace.ClientTrace
+
 	defaultContentType string
 }
 
+// Clone returns a copy of the Query, with its own copy of the Body and
+// ExtraHeaders, but the same InterpretResponse function and Trace.
+func (q *Query) Clone() *Query {
+	c := *q
+	if q.Body != nil {
+		c.Body = append([]byte{}, q.Body...)
+	}
+	if q.ExtraHeaders != nil {
+		c.ExtraHeaders = make(map[string]string, len(q.ExtraHeaders))
+		for k, v := range q.ExtraHeaders {
+			c.ExtraHeaders[k] = v
+		}
+	}
+	return &c
+}
+
 // Do sends the query and returns the result.
 // If optionalClient is nil, a default Client is used.
 // maxRetries is a number of retries, so the first attempt doesn't count, e.g. if maxRetries is 2, up to 3 attempts can be made.
 func (q *Query) Do(optionalClient *Client, maxRetries uint) *Result {
+	return q.doWithContext(context.Background(), optionalClient, maxRetries, false)
+}
+
+// DoStatus sends the query like Do, but only returns the status code of the
+// reply (or 0 if there was none) and the error.
+// The reply body is read and discarded without being retained, so that the
+// connection can be reused, which makes it cheap for e.g. health checks; as a
+// result, the InterpretResponse function sees a nil Result Body.
+func (q *Query) DoStatus(optionalClient *Client, maxRetries uint) (int, error) {
+	r := q.doWithContext(context.Background(), optionalClient, maxRetries, true)
+	if r.Resp == nil {
+		return 0, r.Err
+	}
+	return r.Resp.StatusCode, r.Err
+}
+
+// doWithContext is Do, with a context that cancels the query and its retries,
+// and the option to discard the reply body instead of returning it.
+func (q *Query) doWithContext(ctx context.Context, optionalClient *Client, maxRetries uint, discardBody bool) *Result {
 	if optionalClient == nil {
 		optionalClient = NewClient()
 	}
-	r, verb := &Result{Query: q}, q.verb()
-	req, err := http.NewRequest(verb, q.URL, nil)
-	if err != nil {
-		r.Err = fmt.Errorf("error while crafting %s query to %s - %w", verb, q.URL, err)
-		return r
-	}
-	r.Req = req
-	defaultContentType := q.defaultContentType
-	for k, v := range q.ExtraHeaders {
-		req.Header.Add(k, v)
-		if defaultContentType != "" && lowerStrEqual(k, "content-type") {
-			defaultContentType = ""
-		}
+	r := &Result{Query: q}
+	if q.Trace != nil {
+		ctx = httptrace.WithClientTrace(ctx, q.Trace)
 	}
-	if defaultContentType != "" {
-		req.Header.Add("Content-Type", defaultContentType)
+	var err error
+	if r.Req, err = q.prepare(ctx, optionalClient); err != nil {
+		r.Err = err
+		return r
 	}
 	interpretResponse := oil.If(q.InterpretResponse == nil, DefaultInterpretResponse, q.InterpretResponse)
 	for {
-		req.Body = io.NopCloser(bytes.NewReader(q.Body))
-		if r.Body, r.Resp, err = q.do(optionalClient.HttpClient, req); err == nil {
+		r.Req.Body = io.NopCloser(bytes.NewReader(q.Body)) // r.Req can be changed by interpretResponse
+		if r.Body, r.Resp, err = q.do(optionalClient.HttpClient, r.Req, discardBody); err == nil {
 			var retry bool
 			if err, retry = interpretResponse(r, maxRetries); err == nil || !retry {
 				return r
 			}
 		}
-		if maxRetries == 0 {
+		if maxRetries == 0 || ctx.Err() != nil {
 			r.Err = err
 			return r
 		}
@@ -99,6 +154,119 @@ func (q *Query) Do(optionalClient *Client, maxRetries uint) *Result {
 	}
 }
 
+// Prepare returns the http.Request that Do would send, with its headers and
+// Body, without sending it.
+// If optionalClient is nil, a default Client is used.
+func (q *Query) Prepare(optionalClient *Client) (*http.Request, error) {
+	if optionalClient == nil {
+		optionalClient = NewClient()
+	}
+	req, err := q.prepare(context.Background(), optionalClient)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(q.Body))
+	return req, nil
+}
+
+// CurlString returns a curl command sending the same request as Do, e.g. to
+// reproduce it from a shell.
+// If optionalClient is nil, a default Client is used.
+func (q *Query) CurlString(optionalClient *Client) (string, error) {
+	if optionalClient == nil {
+		optionalClient = NewClient()
+	}
+	req, err := q.prepare(context.Background(), optionalClient)
+	if err != nil {
+		return "", err
+	}
+	args := []string{"curl", "-X", req.Method}
+	keys := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range req.Header[k] {
+			args = append(args, "-H", shellQuote(k+": "+v))
+		}
+	}
+	if len(q.Body) > 0 {
+		args = append(args, "--data-binary", shellQuote(string(q.Body)))
+	}
+	return strings.Join(append(args, shellQuote(req.URL.String())), " "), nil
+}
+
+func shellQuote(s string) string { return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'" }
+
+// prepare crafts the http.Request of the query, without its Body, which must
+// be set before each attempt.
+func (q *Query) prepare(ctx context.Context, c *Client) (*http.Request, error) {
+	verb, url := q.verb(), c.url(q.URL)
+	req, err := http.NewRequestWithContext(ctx, verb, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error while crafting %s query to %s - %w", verb, url, err)
+	}
+	defaultContentType, defaultAcceptEncoding := q.defaultContentType, oil.If(c.rawBody, "gzip", "")
+	for k, v := range q.ExtraHeaders {
+		req.Header.Add(k, v)
+		if defaultContentType != "" && lowerStrEqual(k, "content-type") {
+			defaultContentType = ""
+		}
+		if defaultAcceptEncoding != "" && lowerStrEqual(k, "accept-encoding") {
+			defaultAcceptEncoding = ""
+		}
+	}
+	if defaultContentType != "" {
+		req.Header.Add("Content-Type", defaultContentType)
+	}
+	if defaultAcceptEncoding != "" {
+		req.Header.Add("Accept-Encoding", defaultAcceptEncoding)
+	}
+	if q.ExpectContinue {
+		req.Header.Set("Expect", "100-continue")
+	}
+	return req, nil
+}
+
+// DoBatch sends several queries concurrently, with at most concurrency
+// queries in flight at any time (or all of them if concurrency is <= 0), and
+// returns their results in the same order as the queries.
+// Each query is sent like with Do, including the retries.
+// If the context is done, the queries in flight are cancelled, and the queries
+// not sent yet aren't sent at all: their Result only has an error.
+// Queries mustn't be modified until DoBatch returns, and each Query can only
+// appear once in the slice (DoWithJSON queries can't be batched, marshal
+// their body beforehand instead).
+func DoBatch(ctx context.Context, optionalClient *Client, maxRetries uint, queries []*Query, concurrency int) []*Result {
+	if optionalClient == nil {
+		optionalClient = NewClient()
+	}
+	if concurrency <= 0 {
+		concurrency = len(queries)
+	}
+	results, sem := make([]*Result, len(queries)), make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, q := range queries {
+		if ctx.Err() == nil {
+			select {
+			case sem <- struct{}{}:
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					results[i] = q.doWithContext(ctx, optionalClient, maxRetries, false)
+					<-sem
+				}()
+				continue
+			case <-ctx.Done():
+			}
+		}
+		results[i] = &Result{Query: q, Err: fmt.Errorf("%s query to %s not sent - %w", q.verb(), q.URL, ctx.Err())}
+	}
+	wg.Wait()
+	return results
+}
+
 // tests whether two string are equal in a case insensitive way
 func lowerStrEqual(sa, sb string) bool {
 	// the code's a bit hard to read, but check the unit test to gain confidence: it tries all sorts of combinations
@@ -131,15 +299,20 @@ func (q *Query) DoWithJSON(optionalClient *Client, maxRetries uint, body any) *R
 
 func (q *Query) verb() string { return oil.If(q.Verb == "", "GET", q.Verb) }
 
-func (q *Query) do(httpClient *http.Client, req *http.Request) ([]byte /* body */, *http.Response, error) {
+func (q *Query) do(httpClient *http.Client, req *http.Request, discardBody bool) ([]byte /* body */, *http.Response, error) {
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, nil, fmt.Errorf("%s query to %s failed - %w", req.Method, q.URL, err)
+		return nil, nil, fmt.Errorf("%s query to %s failed - %w", req.Method, req.URL, err)
 	}
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	var body []byte
+	if discardBody {
+		_, err = io.Copy(io.Discard, resp.Body)
+	} else {
+		body, err = io.ReadAll(resp.Body)
+	}
 	if err != nil {
-		return nil, resp, fmt.Errorf("error while reading response body to %s query to %s (reply status %q) - %w", req.Method, q.URL, resp.Status, err)
+		return nil, resp, fmt.Errorf("error while reading response body to %s query to %s (reply status %q) - %w", req.Method, req.URL, resp.Status, err)
 	}
 	return body, resp, nil
 }
@@ -147,6 +320,12 @@ func (q *Query) do(httpClient *http.Client, req *http.Request) ([]byte /* body *
 // Client contains the resources used across multiple queries.
 type Client struct {
 	HttpClient *http.Client
+
+	// if not empty, the URL of queries whose URL is a path starting with "/" is
+	// this BaseURL followed by that path, e.g. "https://example.com/api"
+	BaseURL string
+
+	rawBody bool // set by WithRawBody
 }
 
 // NewClient creates a new Client.
@@ -162,6 +341,152 @@ func (c *Client) WithTimeout(t time.Duration) *Client {
 	return c
 }
 
+// WithHTTP2 makes the Client use HTTP/2 and returns the Client itself.
+// If prior is true, the Client uses HTTP/2 over cleartext TCP connections
+// (h2c) with prior knowledge, i.e. it talks HTTP/2 right away without any
+// upgrade negotiation, which only works with servers that support h2c, and
+// http:// URLs: the Client can't be used for https:// URLs anymore.  The
+// Transport of the http.Client is replaced by an http2.Transport, so settings
+// made earlier by WithRawBody etc are lost, and those made later don't apply.
+// If prior is false, the Client negotiates HTTP/2 during the TLS handshake of
+// https:// URLs and falls back to HTTP/1.1 if the server doesn't support it,
+// while http:// URLs use HTTP/1.1.  net/http already does that by default,
+// but not with a custom TLSClientConfig or dialer, or if HTTP/2 was disabled
+// with an empty TLSNextProto; the Transport of the http.Client is replaced
+// like WithRawBody does, which enables HTTP/2 in all these cases.
+func (c *Client) WithHTTP2(prior bool) *Client {
+	if prior {
+		c.HttpClient.Transport = &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		}
+		return c
+	}
+	t := c.cloneTransport()
+	t.ForceAttemptHTTP2 = true
+	if _, ok := t.TLSNextProto["h2"]; !ok {
+		t.TLSNextProto = nil // a non-nil TLSNextProto without h2 disables HTTP/2
+	}
+	c.HttpClient.Transport = t
+	return c
+}
+
+// WithRawBody makes the Client return reply bodies exactly as the server sent
+// them, without decompressing them, and returns the Client itself.
+// By default, net/http asks servers for gzip compression, transparently
+// decompresses gzip reply bodies, and removes their Content-Encoding header.
+// With WithRawBody, the Client still sends an Accept-Encoding: gzip header
+// (unless the Query's ExtraHeaders have their own Accept-Encoding), but Result
+// Body fields are left compressed and Result.ContentEncoding tells how.
+// It replaces the Transport of the http.Client by a clone with
+// DisableCompression set; if that Transport isn't an *http.Transport (e.g. after
+// WithHTTP2(true)), the clone is made from http.DefaultTransport instead, or
+// if that's not an *http.Transport either, a new one is used.
+func (c *Client) WithRawBody() *Client {
+	t := c.cloneTransport()
+	t.DisableCompression = true
+	c.HttpClient.Transport, c.rawBody = t, true
+	return c
+}
+
+// WithExpectContinueTimeout sets how long the Client waits for the server's
+// go-ahead before sending the body of queries with ExpectContinue set, and
+// returns the Client itself.
+// If the server doesn't reply within that time, the body is sent anyway.
+// It replaces the Transport of the http.Client like WithRawBody does.
+func (c *Client) WithExpectContinueTimeout(d time.Duration) *Client {
+	t := c.cloneTransport()
+	t.ExpectContinueTimeout = d
+	c.HttpClient.Transport = t
+	return c
+}
+
+// url returns the URL to use for a query whose URL field is queryURL.
+func (c *Client) url(queryURL string) string {
+	if c.BaseURL == "" || !strings.HasPrefix(queryURL, "/") {
+		return queryURL
+	}
+	return strings.TrimSuffix(c.BaseURL, "/") + queryURL
+}
+
+// WithConnectionPool tunes the reuse of idle connections: it sets the maximum
+// number of idle connections kept per host, and how long they're kept, and
+// returns the Client itself.
+// It replaces the Transport of the http.Client like WithRawBody does.
+func (c *Client) WithConnectionPool(maxIdlePerHost int, idleTimeout time.Duration) *Client {
+	t := c.cloneTransport()
+	t.MaxIdleConnsPerHost, t.IdleConnTimeout = maxIdlePerHost, idleTimeout
+	c.HttpClient.Transport = t
+	return c
+}
+
+// cloneTransport clones the Transport of the http.Client if it's an
+// *http.Transport, or otherwise http.DefaultTransport if it's one, or returns
+// a new *http.Transport.
+func (c *Client) cloneTransport() *http.Transport {
+	if t, ok := c.HttpClient.Transport.(*http.Transport); ok {
+		return t.Clone()
+	}
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		return t.Clone()
+	}
+	return &http.Transport{}
+}
+
+// ContentEncoding returns the Content-Encoding header of the reply, e.g. "gzip"
+// if the Body is gzip compressed, or "" if there's no such header or no reply.
+// Unless the Client was set up with WithRawBody or the Query has its own
+// Accept-Encoding header, it's always "" because net/http decompresses the body.
+func (r *Result) ContentEncoding() string {
+	if r.Resp == nil {
+		return ""
+	}
+	return r.Resp.Header.Get("Content-Encoding")
+}
+
+// Save writes the reply body to a file, atomically, by writing a temporary
+// file in the same directory and renaming it.
+// If the query failed, it writes nothing and just returns r.Err.
+func (r *Result) Save(path string) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("creating temporary file to save the reply to the %s query to %s failed - %w", r.Req.Method, r.Query.URL, err)
+	}
+	_, err = f.Write(r.Body)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("saving the reply to the %s query to %s to %s failed - %w", r.Req.Method, r.Query.URL, path, err)
+	}
+	return nil
+}
+
+// BunchWriter is an interface to write files in a bunch of files.
+// It's implemented by github.com/bcogs/golibs/bunch.Bunch.
+type BunchWriter interface {
+	Write(relPath []string, reader io.Reader) error
+}
+
+// SaveToBunch writes the reply body to a file of a bunch, typically a
+// *bunch.Bunch, that takes care of writing it atomically.
+// If the query failed, it writes nothing and just returns r.Err.
+func (r *Result) SaveToBunch(b BunchWriter, relPath []string) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	return b.Write(relPath, bytes.NewReader(r.Body))
+}
+
 // DeJSON unmarshals the json body after an http request.
 // It's meant to wrap Do* Query method calls, and correctly handles the situation if the query fails.
 // Example use:
@@ -179,6 +504,20 @@ func DeJSON[T any](r *Result) ( /* unmarshaled reply body */ *T, *Result) {
 	return x, r
 }
 
+// DeJSONStream is like DeJSON, but instead of unmarshaling the json body at
+// once, it returns a json.Decoder reading it, e.g. to decode the elements of a
+// large array one by one, with its Token and More methods, without holding
+// them all in memory.  The decoding errors are returned by the Decoder, not in
+// the Result.  If r.Err isn't nil, the returned Decoder is nil.
+// The Decoder reads the buffered Body of the Result: the Do* Query methods
+// always read the whole reply body, there's no streaming of the reply itself.
+func DeJSONStream(r *Result) (*json.Decoder, *Result) {
+	if r.Err != nil {
+		return nil, r
+	}
+	return json.NewDecoder(bytes.NewReader(r.Body)), r
+}
+
 // DefaultInterpretResponse is the default function used to interpret http
 // responses after a query that succeeded at the http layer.
 // It succeeds if the status code is 2xx, and otherwise returns an error.
@@ -2,17 +2,29 @@ package htt9
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"os"
+	"path/filepath"
 	"strings"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/bcogs/golibs/oil"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 type server struct {
@@ -23,6 +35,8 @@ type server struct {
 	// modify these to change what the server replies
 	replyStatus func() int // provides the return code (200 if nil)
 	replyBody   []byte     // default: nil
+	// optional function that provides headers to set in the reply, given the request
+	replyHeaders func(req *http.Request) map[string]string
 
 	req     *http.Request // latest request received by the server
 	reqBody []byte
@@ -58,6 +72,11 @@ func (s *server) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	require.NoError(s.t, err)
 	s.reqBody = b
 	rw.Header().Set("x-htt9", "grut")
+	if s.replyHeaders != nil {
+		for k, v := range s.replyHeaders(req) {
+			rw.Header().Set(k, v)
+		}
+	}
 	if s.replyStatus == nil {
 		rw.WriteHeader(200)
 	} else {
@@ -382,3 +401,478 @@ func testLowerStrEqual(t *testing.T) {
 	require.False(t, lowerStrEqual("ab", "abc"))
 	require.False(t, lowerStrEqual("ab", "a"))
 }
+
+func TestWithHTTP2(t *testing.T) {
+	t.Parallel()
+	s := &server{t: t}
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	s.listener = listener
+	s.httpServer = &http.Server{Handler: h2c.NewHandler(s, &http2.Server{})}
+	go s.httpServer.Serve(listener)
+	defer s.Close()
+	url := s.URL() + "/testWithHTTP2"
+
+	r := (&Query{URL: url}).Do(NewClient(), 0)
+	require.NoError(t, r.Err)
+	require.Equal(t, 1, r.Resp.ProtoMajor)
+
+	r = (&Query{URL: url, Verb: "POST", Body: []byte("h2c")}).Do(NewClient().WithHTTP2(true), 0)
+	require.NoError(t, r.Err)
+	require.Equal(t, 2, r.Resp.ProtoMajor)
+	require.Equal(t, 2, s.req.ProtoMajor)
+	require.Equal(t, "h2c", string(s.reqBody))
+
+	c := NewClient().WithHTTP2(false)
+	require.IsType(t, &http.Transport{}, c.HttpClient.Transport)
+	r = (&Query{URL: url}).Do(c, 0)
+	require.NoError(t, r.Err)
+	require.Equal(t, 1, r.Resp.ProtoMajor)
+}
+
+func TestWithHTTP2CombinedOptions(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(req.Proto))
+	}))
+	s.EnableHTTP2 = true
+	s.StartTLS()
+	defer s.Close()
+	tlsConfig := s.Client().Transport.(*http.Transport).TLSClientConfig
+	newClient := func(nextProto map[string]func(string, *tls.Conn) http.RoundTripper) *Client {
+		c := NewClient()
+		// a custom TLS config disables HTTP/2, unless ForceAttemptHTTP2 is set
+		c.HttpClient.Transport = &http.Transport{TLSClientConfig: tlsConfig.Clone(), TLSNextProto: nextProto}
+		return c
+	}
+	r := (&Query{URL: s.URL}).Do(newClient(nil), 0)
+	require.NoError(t, r.Err)
+	require.Equal(t, "HTTP/1.1", string(r.Body))
+
+	for _, c := range []*Client{
+		newClient(nil).WithHTTP2(false).WithRawBody().WithConnectionPool(7, time.Minute).WithExpectContinueTimeout(time.Second),
+		newClient(nil).WithRawBody().WithConnectionPool(7, time.Minute).WithExpectContinueTimeout(time.Second).WithHTTP2(false),
+		newClient(map[string]func(string, *tls.Conn) http.RoundTripper{}).WithRawBody().WithConnectionPool(7, time.Minute).WithExpectContinueTimeout(time.Second).WithHTTP2(false),
+	} {
+		tr := c.HttpClient.Transport.(*http.Transport)
+		require.True(t, tr.DisableCompression)
+		require.True(t, c.rawBody)
+		require.Equal(t, 7, tr.MaxIdleConnsPerHost)
+		require.Equal(t, time.Minute, tr.IdleConnTimeout)
+		require.Equal(t, time.Second, tr.ExpectContinueTimeout)
+		r := (&Query{URL: s.URL}).Do(c, 0)
+		require.NoError(t, r.Err)
+		require.Equal(t, 2, r.Resp.ProtoMajor)
+		require.Equal(t, "HTTP/2.0", string(r.Body))
+	}
+}
+
+func TestWithRawBody(t *testing.T) {
+	t.Parallel()
+	s := newServer(t)
+	defer s.Close()
+	url := s.URL() + "/testWithRawBody"
+	var gzipped bytes.Buffer
+	w := gzip.NewWriter(&gzipped)
+	require.NoError(t, oil.Second(w.Write([]byte("hello hello hello"))))
+	require.NoError(t, w.Close())
+	s.replyBody = gzipped.Bytes()
+	s.replyHeaders = func(req *http.Request) map[string]string {
+		return map[string]string{"Content-Encoding": oil.If(strings.Contains(req.Header.Get("Accept-Encoding"), "gzip"), "gzip", "")}
+	}
+
+	r := (&Query{URL: url}).Do(NewClient(), 0)
+	require.NoError(t, r.Err)
+	require.Equal(t, "hello hello hello", string(r.Body))
+	require.Equal(t, "", r.ContentEncoding())
+
+	c := NewClient().WithRawBody()
+	r = (&Query{URL: url}).Do(c, 0)
+	require.NoError(t, r.Err)
+	require.Equal(t, gzipped.Bytes(), r.Body)
+	require.Equal(t, "gzip", r.ContentEncoding())
+	require.Equal(t, "gzip", s.req.Header.Get("Accept-Encoding"))
+
+	r = (&Query{URL: url, ExtraHeaders: map[string]string{"accept-encoding": "identity"}}).Do(c, 0)
+	require.NoError(t, r.Err)
+	require.Equal(t, []string{"identity"}, s.req.Header["Accept-Encoding"])
+	require.Equal(t, "", r.ContentEncoding())
+
+	require.Equal(t, "", (&Result{}).ContentEncoding())
+}
+
+func TestDoBatch(t *testing.T) {
+	t.Parallel()
+	const concurrency = 3
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = oil.Max(maxInFlight, inFlight)
+		mu.Unlock()
+		if req.URL.Path == "/slow" {
+			<-release
+		} else {
+			time.Sleep(time.Millisecond)
+		}
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		if req.URL.Path == "/error" {
+			rw.WriteHeader(500)
+		}
+		rw.Write([]byte(req.URL.Path))
+	}))
+	defer s.Close()
+
+	paths := []string{"/slow", "/a", "/b", "/error", "/c", "/d", "/e", "/f", "/g"}
+	queries := make([]*Query, len(paths))
+	for i, p := range paths {
+		queries[i] = &Query{URL: s.URL + p}
+	}
+	go func() { time.Sleep(time.Second / 10); close(release) }()
+	results := DoBatch(context.Background(), nil, 1, queries, concurrency)
+	require.Len(t, results, len(paths))
+	for i, r := range results {
+		require.Same(t, queries[i], r.Query)
+		require.Equal(t, paths[i], string(r.Body))
+		if paths[i] == "/error" {
+			require.Error(t, r.Err)
+		} else {
+			require.NoError(t, r.Err)
+		}
+	}
+	require.LessOrEqual(t, maxInFlight, concurrency)
+	require.Greater(t, maxInFlight, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results = DoBatch(ctx, nil, 1, queries, concurrency)
+	for i, r := range results {
+		require.Same(t, queries[i], r.Query)
+		require.ErrorIs(t, r.Err, context.Canceled)
+	}
+}
+
+type mockBunch map[string]string
+
+func (m mockBunch) Write(relPath []string, reader io.Reader) error {
+	b, err := io.ReadAll(reader)
+	m[strings.Join(relPath, "/")] = string(b)
+	return err
+}
+
+func TestSave(t *testing.T) {
+	t.Parallel()
+	s := newServer(t)
+	defer s.Close()
+	url := s.URL() + "/testSave"
+	s.replyBody = []byte("saved")
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "file")
+
+	r := (&Query{URL: url}).Do(nil, 0)
+	require.NoError(t, r.Save(path))
+	require.Equal(t, "saved", string(oil.First(os.ReadFile(path))))
+	require.Len(t, oil.First(os.ReadDir(tmp)), 1)
+	require.Error(t, r.Save(filepath.Join(tmp, "noexist", "file")))
+	require.Len(t, oil.First(os.ReadDir(tmp)), 1)
+	b := mockBunch{}
+	require.NoError(t, r.SaveToBunch(b, []string{"foo", "bar"}))
+	require.Equal(t, mockBunch{"foo/bar": "saved"}, b)
+
+	s.replyStatus = func() int { return 500 }
+	r = (&Query{URL: url}).Do(nil, 0)
+	require.Error(t, r.Err)
+	require.Equal(t, r.Err, r.Save(filepath.Join(tmp, "failed")))
+	require.Equal(t, r.Err, r.SaveToBunch(b, []string{"failed"}))
+	require.Len(t, oil.First(os.ReadDir(tmp)), 1)
+	require.Len(t, b, 1)
+}
+
+// countingListener counts the bytes read from all the connections it accepts.
+type countingListener struct {
+	net.Listener
+	n *int64
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	return countingConn{conn, l.n}, err
+}
+
+type countingConn struct {
+	net.Conn
+	n *int64
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+func TestExpectContinue(t *testing.T) {
+	t.Parallel()
+	var received int64
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Expect") == "100-continue" {
+			rw.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+		io.Copy(io.Discard, req.Body)
+	}))
+	s.Listener = countingListener{s.Listener, &received}
+	s.Start()
+	defer s.Close()
+	url := s.URL + "/testExpectContinue"
+	body := bytes.Repeat([]byte("x"), 1<<20)
+	c := NewClient().WithExpectContinueTimeout(10 * time.Second)
+
+	r := (&Query{URL: url, Verb: "PUT", Body: body, ExpectContinue: true}).Do(c, 0)
+	require.Error(t, r.Err)
+	require.Equal(t, http.StatusExpectationFailed, r.Resp.StatusCode)
+	require.Equal(t, "100-continue", r.Req.Header.Get("Expect"))
+	require.Less(t, atomic.LoadInt64(&received), int64(len(body)))
+
+	r = (&Query{URL: url, Verb: "PUT", Body: body}).Do(c, 0)
+	require.NoError(t, r.Err)
+	require.Greater(t, atomic.LoadInt64(&received), int64(len(body)))
+}
+
+func TestBaseURL(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(req.URL.Path))
+	}))
+	defer s.Close()
+	c := NewClient()
+	c.BaseURL = s.URL + "/api/"
+
+	r := (&Query{URL: "/foo?x=y"}).Do(c, 0)
+	require.NoError(t, r.Err)
+	require.Equal(t, "/api/foo", string(r.Body))
+	require.Equal(t, "x=y", r.Req.URL.RawQuery)
+
+	r = (&Query{URL: s.URL + "/bar"}).Do(c, 0)
+	require.NoError(t, r.Err)
+	require.Equal(t, "/bar", string(r.Body))
+
+	c.BaseURL = ""
+	r = (&Query{URL: "/foo"}).Do(c, 0)
+	require.Error(t, r.Err)
+}
+
+func TestWithConnectionPool(t *testing.T) {
+	t.Parallel()
+	c := NewClient().WithRawBody().WithConnectionPool(50, time.Minute)
+	tr := c.HttpClient.Transport.(*http.Transport)
+	require.Equal(t, 50, tr.MaxIdleConnsPerHost)
+	require.Equal(t, time.Minute, tr.IdleConnTimeout)
+	require.True(t, tr.DisableCompression)
+	require.NotEqual(t, 50, http.DefaultTransport.(*http.Transport).MaxIdleConnsPerHost)
+
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer s.Close()
+	require.NoError(t, (&Query{URL: s.URL}).Do(c, 0).Err)
+}
+
+func TestTrace(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {}))
+	defer s.Close()
+	var gotConn, gotFirstByte atomic.Int32
+	q := &Query{URL: s.URL, Trace: &httptrace.ClientTrace{
+		GotConn:              func(httptrace.GotConnInfo) { gotConn.Add(1) },
+		GotFirstResponseByte: func() { gotFirstByte.Add(1) },
+	}}
+	require.NoError(t, q.Do(nil, 0).Err)
+	require.Equal(t, int32(1), gotConn.Load())
+	require.Equal(t, int32(1), gotFirstByte.Load())
+	require.NoError(t, q.Do(nil, 0).Err)
+	require.Equal(t, int32(2), gotFirstByte.Load())
+}
+
+func TestInterpretResponseRewritesRequest(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer fresh" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		rw.Write([]byte(req.URL.Path + " " + string(body)))
+	}))
+	defer s.Close()
+	attempts := 0
+	q := &Query{
+		URL:          s.URL + "/foo",
+		Body:         []byte("bar"),
+		ExtraHeaders: map[string]string{"Authorization": "Bearer stale"},
+		InterpretResponse: func(r *Result, retriesLeft uint) (error, bool) {
+			attempts++
+			if r.Resp.StatusCode == http.StatusUnauthorized {
+				r.Req.Header.Set("Authorization", "Bearer fresh")
+				return errors.New("unauthorized"), true
+			}
+			return DefaultInterpretResponse(r, retriesLeft)
+		},
+	}
+	r := q.Do(nil, 1)
+	require.NoError(t, r.Err)
+	require.Equal(t, 2, attempts)
+	require.Equal(t, "/foo bar", string(r.Body))
+
+	attempts = 0
+	q.InterpretResponse = func(r *Result, retriesLeft uint) (error, bool) {
+		attempts++
+		if r.Resp.StatusCode == http.StatusUnauthorized {
+			req := r.Req.Clone(r.Req.Context())
+			req.URL.Path = "/baz"
+			req.Header.Set("Authorization", "Bearer fresh")
+			r.Req = req
+			return errors.New("unauthorized"), true
+		}
+		return DefaultInterpretResponse(r, retriesLeft)
+	}
+	r = q.Do(nil, 1)
+	require.NoError(t, r.Err)
+	require.Equal(t, 2, attempts)
+	require.Equal(t, "/baz bar", string(r.Body))
+}
+
+func TestClone(t *testing.T) {
+	t.Parallel()
+	q := &Query{URL: "http://example.com", Body: []byte("foo"), ExtraHeaders: map[string]string{"X-Foo": "bar"}, Verb: "PUT", ExpectContinue: true}
+	c := q.Clone()
+	require.Equal(t, q, c)
+	c.Body[0], c.ExtraHeaders["X-Foo"], c.ExtraHeaders["X-Baz"], c.URL = 'g', "qux", "quux", "http://example.org"
+	require.Equal(t, &Query{URL: "http://example.com", Body: []byte("foo"), ExtraHeaders: map[string]string{"X-Foo": "bar"}, Verb: "PUT", ExpectContinue: true}, q)
+	require.Equal(t, &Query{}, (&Query{}).Clone())
+
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(req.Header.Get("X-N")))
+	}))
+	defer s.Close()
+	template := &Query{URL: s.URL, ExtraHeaders: map[string]string{}}
+	results := make([]*Result, 10)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			q := template.Clone()
+			q.ExtraHeaders["X-N"] = strconv.Itoa(i)
+			results[i] = q.Do(nil, 0)
+		}(i)
+	}
+	wg.Wait()
+	for i, r := range results {
+		require.NoError(t, r.Err)
+		require.Equal(t, strconv.Itoa(i), string(r.Body))
+	}
+	require.Empty(t, template.ExtraHeaders)
+}
+
+func TestPrepareAndCurlString(t *testing.T) {
+	t.Parallel()
+	c := NewClient().WithRawBody()
+	c.BaseURL = "https://example.com/api"
+	q := &Query{URL: "/foo?x=1", Verb: "POST", Body: []byte(`{"it's": 1}`), ExtraHeaders: map[string]string{"X-Foo": "bar"}}
+	q.defaultContentType = "application/json"
+	req, err := q.Prepare(c)
+	require.NoError(t, err)
+	require.Equal(t, "POST", req.Method)
+	require.Equal(t, "https://example.com/api/foo?x=1", req.URL.String())
+	require.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	require.Equal(t, "bar", req.Header.Get("X-Foo"))
+	require.Equal(t, "gzip", req.Header.Get("Accept-Encoding"))
+	body, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	require.Equal(t, q.Body, body)
+
+	s, err := q.CurlString(c)
+	require.NoError(t, err)
+	require.Equal(t, `curl -X POST -H 'Accept-Encoding: gzip' -H 'Content-Type: application/json' -H 'X-Foo: bar' --data-binary '{"it'\''s": 1}' 'https://example.com/api/foo?x=1'`, s)
+	s, err = (&Query{URL: "http://example.com"}).CurlString(nil)
+	require.NoError(t, err)
+	require.Equal(t, `curl -X GET 'http://example.com'`, s)
+
+	_, err = (&Query{URL: "http://example.com", Verb: "BAD VERB"}).Prepare(nil)
+	require.Error(t, err)
+	_, err = (&Query{URL: "http://example.com", Verb: "BAD VERB"}).CurlString(nil)
+	require.Error(t, err)
+}
+
+func TestDoStatus(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/down" {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+		rw.Write(bytes.Repeat([]byte("x"), 100000))
+	}))
+	defer s.Close()
+	var conns, reused atomic.Int32
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+		conns.Add(1)
+		if info.Reused {
+			reused.Add(1)
+		}
+	}}
+	c := NewClient()
+	for i := 0; i < 5; i++ {
+		status, err := (&Query{URL: s.URL, Trace: trace}).DoStatus(c, 0)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, status)
+	}
+	status, err := (&Query{URL: s.URL + "/down", Trace: trace}).DoStatus(c, 1)
+	require.ErrorContains(t, err, "503")
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.Equal(t, int32(7), conns.Load())
+	require.Equal(t, int32(6), reused.Load())
+
+	status, err = (&Query{URL: s.URL, InterpretResponse: func(r *Result, retriesLeft uint) (error, bool) {
+		require.Nil(t, r.Body)
+		return nil, false
+	}}).DoStatus(c, 0)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, status)
+	status, err = (&Query{URL: "http://127.0.0.1:0"}).DoStatus(c, 0)
+	require.Error(t, err)
+	require.Zero(t, status)
+}
+
+func TestDeJSONStream(t *testing.T) {
+	t.Parallel()
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/fail" {
+			rw.WriteHeader(http.StatusInternalServerError)
+		}
+		rw.Write([]byte(`[{"foo": 1}, {"foo": 2}, {"foo": 3}]`))
+	}))
+	defer s.Close()
+	d, r := DeJSONStream((&Query{URL: s.URL}).Do(nil, 0))
+	require.NoError(t, r.Err)
+	tok, err := d.Token()
+	require.NoError(t, err)
+	require.Equal(t, json.Delim('['), tok)
+	var foos []int
+	for d.More() {
+		var elem struct{ Foo int }
+		require.NoError(t, d.Decode(&elem))
+		foos = append(foos, elem.Foo)
+	}
+	require.Equal(t, []int{1, 2, 3}, foos)
+	tok, err = d.Token()
+	require.NoError(t, err)
+	require.Equal(t, json.Delim(']'), tok)
+	_, err = d.Token()
+	require.ErrorIs(t, err, io.EOF)
+
+	d, r = DeJSONStream((&Query{URL: s.URL + "/fail"}).Do(nil, 0))
+	require.Error(t, r.Err)
+	require.Nil(t, d)
+}
@@ -1,9 +1,16 @@
 // Package nummap provides maps of arbitrary keys to numeric values that can be
 // accessed concurrently and read-edit-written atomically.
+// The operations that compare values, like MaxEntry or SetIfGreater, are
+// functions rather than methods, because they only work for NumMaps of ordered
+// numbers, i.e. not complex numbers.
 package nummap
 
 import (
+	"encoding/json"
+	"reflect"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/bcogs/golibs/oil"
 )
@@ -11,8 +18,9 @@ import (
 // NumMap maps any type of key to any type of number and allows to manipulate
 // those numbers in a concurrency safe fashion.
 type NumMap[K comparable, V oil.Number] struct {
-	mu sync.Mutex // PROTECTS EVERYTHING BELOW
-	m  map[K]V
+	mu         sync.Mutex // PROTECTS EVERYTHING BELOW
+	m          map[K]V
+	thresholds map[K][]func(before, after V) // see SetThreshold
 }
 
 // NewNumMap creates a NumMap.
@@ -22,12 +30,26 @@ func NewNumMap[K comparable, V oil.Number]() *NumMap[K, V] { return &NumMap[K, V
 func (cm *NumMap[K, V]) Add(key K, value V) V {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
-	v := cm.m[key]
-	v += value
+	before := cm.m[key]
+	v := before + value
 	cm.m[key] = v
+	cm.checkThresholds(key, before, v)
 	return v
 }
 
+// AddMany adds the values of a map to the entries with the same keys, locking
+// the NumMap only once.
+// Thresholds registered with SetThreshold are checked like with Add.
+func (cm *NumMap[K, V]) AddMany(other map[K]V) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	for k, delta := range other {
+		before := cm.m[k]
+		cm.m[k] = before + delta
+		cm.checkThresholds(k, before, before+delta)
+	}
+}
+
 // Apply applies an arbitrary function to an entry of the map and returns the result and the initial value.
 func (cm *NumMap[K, V]) Apply(key K, f func(v V) V) (before, after V) {
 	cm.mu.Lock()
@@ -35,9 +57,40 @@ func (cm *NumMap[K, V]) Apply(key K, f func(v V) V) (before, after V) {
 	before = cm.m[key]
 	after = f(before)
 	cm.m[key] = after
+	cm.checkThresholds(key, before, after)
 	return
 }
 
+// checkThresholds calls the threshold checkers registered for a key with SetThreshold.
+func (cm *NumMap[K, V]) checkThresholds(key K, before, after V) {
+	for _, check := range cm.thresholds[key] {
+		check(before, after)
+	}
+}
+
+// Clear deletes all the entries of the NumMap.
+func (cm *NumMap[K, V]) Clear() {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.m = make(map[K]V)
+}
+
+// CompareAndSwap sets an entry of the map to a new value if its current value
+// is equal to an old value, and returns whether it did.
+// A missing entry is considered to have a 0 value.
+func (cm *NumMap[K, V]) CompareAndSwap(key K, oldValue, newValue V) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.m[key] != oldValue {
+		return false
+	}
+	cm.m[key] = newValue
+	return true
+}
+
+// Decrement subtracts 1 from an entry of the map and returns the result.
+func (cm *NumMap[K, V]) Decrement(key K) V { return cm.Sub(key, 1) }
+
 // Delete deletes an entry from the NumMap.
 func (cm *NumMap[K, V]) Delete(key K) {
 	cm.mu.Lock()
@@ -45,6 +98,20 @@ func (cm *NumMap[K, V]) Delete(key K) {
 	delete(cm.m, key)
 }
 
+// Filter returns a copy of the entries of the map for which a predicate returns true.
+// The map is locked while the predicate is called, so it mustn't call methods of the NumMap.
+func (cm *NumMap[K, V]) Filter(predicate func(key K, value V) bool) map[K]V {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	m := make(map[K]V)
+	for k, v := range cm.m {
+		if predicate(k, v) {
+			m[k] = v
+		}
+	}
+	return m
+}
+
 // Get reads an entry of the map.
 func (cm *NumMap[K, V]) Get(k K) V {
 	cm.mu.Lock()
@@ -52,6 +119,44 @@ func (cm *NumMap[K, V]) Get(k K) V {
 	return cm.m[k]
 }
 
+// GetAndReset reads an entry of the map and sets it to 0, atomically.
+// It returns the value read.
+func (cm *NumMap[K, V]) GetAndReset(key K) V {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	v, ok := cm.m[key]
+	if ok {
+		cm.m[key] = 0
+	}
+	return v
+}
+
+// GetOrSet returns the value of an entry of the map if it exists, or otherwise
+// sets it to a default value and returns that value.
+func (cm *NumMap[K, V]) GetOrSet(key K, defaultValue V) V {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if v, ok := cm.m[key]; ok {
+		return v
+	}
+	cm.m[key] = defaultValue
+	return defaultValue
+}
+
+// Increment adds 1 to an entry of the map and returns the result.
+func (cm *NumMap[K, V]) Increment(key K) V { return cm.Add(key, 1) }
+
+// Keys returns the keys of the map, in no particular order.
+func (cm *NumMap[K, V]) Keys() []K {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	keys := make([]K, 0, len(cm.m))
+	for k := range cm.m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
 // Len returns the NumMap len.
 func (cm *NumMap[K, V]) Len() int {
 	cm.mu.Lock()
@@ -59,6 +164,38 @@ func (cm *NumMap[K, V]) Len() int {
 	return len(cm.m)
 }
 
+// MarshalJSON encodes a snapshot of the map as a JSON object.
+// It fails for complex values, which JSON can't represent, and for keys that
+// encoding/json can't use as object keys.
+func (cm *NumMap[K, V]) MarshalJSON() ([]byte, error) { return json.Marshal(cm.Snapshot()) }
+
+// MergeFrom adds all the values of another NumMap to the entries with the same keys.
+// The other NumMap is snapshotted first, so both maps are never locked at the
+// same time, and merging a NumMap into itself doubles its values.
+func (cm *NumMap[K, V]) MergeFrom(other *NumMap[K, V]) {
+	for k, v := range other.Snapshot() {
+		cm.Add(k, v)
+	}
+}
+
+// MultiApply applies an arbitrary function to several entries of the map, at
+// once from the point of view of other goroutines.
+// All the entries are read before any is written, and f is applied only once
+// to keys listed several times.
+func (cm *NumMap[K, V]) MultiApply(keys []K, f func(v V) V) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	before := make(map[K]V, len(keys))
+	for _, k := range keys {
+		before[k] = cm.m[k]
+	}
+	for k, v := range before {
+		after := f(v)
+		cm.m[k] = after
+		cm.checkThresholds(k, v, after)
+	}
+}
+
 // Set sets an entry of the map to a value.
 func (cm *NumMap[K, V]) Set(k K, v V) {
 	cm.mu.Lock()
@@ -81,8 +218,261 @@ func (cm *NumMap[K, V]) Snapshot() map[K]V {
 func (cm *NumMap[K, V]) Sub(key K, value V) V {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
-	v := cm.m[key]
-	v -= value
+	before := cm.m[key]
+	v := before - value
 	cm.m[key] = v
+	cm.checkThresholds(key, before, v)
 	return v
 }
+
+// Sum returns the sum of all the values of the map.
+func (cm *NumMap[K, V]) Sum() V {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	var sum V
+	for _, v := range cm.m {
+		sum += v
+	}
+	return sum
+}
+
+// UnmarshalJSON replaces all the entries of the map with those of a JSON object.
+// The map is left unchanged if the JSON can't be decoded.
+// Thresholds registered with SetThreshold aren't checked.
+func (cm *NumMap[K, V]) UnmarshalJSON(data []byte) error {
+	m := make(map[K]V)
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.m = m
+	return nil
+}
+
+// MaxEntry returns the key and value of the entry of a NumMap with the largest
+// value, and true, or false if the NumMap is empty.
+// If several entries have the largest value, which one is returned is undefined.
+func MaxEntry[K comparable, V oil.OrderedNumber](cm *NumMap[K, V]) (K, V, bool) {
+	return cm.extremeEntry(func(a, b V) bool { return a > b })
+}
+
+// MinEntry returns the key and value of the entry of a NumMap with the smallest
+// value, and true, or false if the NumMap is empty.
+// If several entries have the smallest value, which one is returned is undefined.
+func MinEntry[K comparable, V oil.OrderedNumber](cm *NumMap[K, V]) (K, V, bool) {
+	return cm.extremeEntry(func(a, b V) bool { return a < b })
+}
+
+// extremeEntry returns the entry whose value x is such that better(x, y) is
+// false for all the values y of the other entries.
+func (cm *NumMap[K, V]) extremeEntry(better func(a, b V) bool) (K, V, bool) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	var bestK K
+	var bestV V
+	found := false
+	for k, v := range cm.m {
+		if !found || better(v, bestV) {
+			bestK, bestV, found = k, v, true
+		}
+	}
+	return bestK, bestV, found
+}
+
+// TopN returns the n entries of a NumMap with the largest values, sorted by
+// decreasing value, or all the entries if there are fewer than n.
+// The order of entries with equal values is undefined.
+func TopN[K comparable, V oil.OrderedNumber](cm *NumMap[K, V], n int) []oil.Pair[K, V] {
+	cm.mu.Lock()
+	entries := make([]oil.Pair[K, V], 0, len(cm.m))
+	for k, v := range cm.m {
+		entries = append(entries, oil.NewPair(k, v))
+	}
+	cm.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Second > entries[j].Second })
+	return entries[:oil.Max(oil.Min(n, len(entries)), 0)]
+}
+
+// SetIfGreater sets an entry of a NumMap to a value if it's greater than the
+// current value, which is 0 for missing entries, and returns whether it did.
+func SetIfGreater[K comparable, V oil.OrderedNumber](cm *NumMap[K, V], key K, v V) bool {
+	return cm.setIf(key, v, func(a, b V) bool { return a > b })
+}
+
+// SetIfLess sets an entry of a NumMap to a value if it's less than the current
+// value, which is 0 for missing entries, and returns whether it did.
+func SetIfLess[K comparable, V oil.OrderedNumber](cm *NumMap[K, V], key K, v V) bool {
+	return cm.setIf(key, v, func(a, b V) bool { return a < b })
+}
+
+// setIf sets an entry of the map to v if better(v, current value).
+func (cm *NumMap[K, V]) setIf(key K, v V, better func(a, b V) bool) bool {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	before := cm.m[key]
+	if !better(v, before) {
+		return false
+	}
+	cm.m[key] = v
+	cm.checkThresholds(key, before, v)
+	return true
+}
+
+// SetThreshold registers a function to call in a new goroutine with the key
+// and the new value whenever an Add, AddMany, Sub, Apply, MultiApply,
+// SetIfGreater or SetIfLess makes the value of an entry of a NumMap cross a
+// threshold: go from below it to at least it if above is true, or from above
+// it to at most it otherwise.
+// Several thresholds can be registered for the same key, and they're never
+// unregistered.  Set and the other methods don't trigger them.
+func SetThreshold[K comparable, V oil.OrderedNumber](cm *NumMap[K, V], key K, threshold V, above bool, fn func(K, V)) {
+	crossed := func(before, after V) bool { return before < threshold && after >= threshold }
+	if !above {
+		crossed = func(before, after V) bool { return before > threshold && after <= threshold }
+	}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.thresholds == nil {
+		cm.thresholds = make(map[K][]func(before, after V))
+	}
+	cm.thresholds[key] = append(cm.thresholds[key], func(before, after V) {
+		if crossed(before, after) {
+			go fn(key, after)
+		}
+	})
+}
+
+// Histogram counts observed values in buckets, and can be used concurrently.
+// The buckets are delimited by boundaries b[0] < b[1] < ... < b[n-1]: bucket 0
+// counts the values < b[0], bucket i counts the values in [b[i-1], b[i]), and
+// bucket n counts the values >= b[n-1], so out of range values end up in the
+// first or last bucket.
+// Observed values are float64s, and the boundaries are converted to float64,
+// using the real part of complex boundaries.
+type Histogram[V oil.Number] struct {
+	boundaries []float64
+	counts     *NumMap[int, uint64]
+}
+
+// NewHistogram creates a Histogram with arbitrary bucket boundaries, which
+// don't need to be sorted.
+func NewHistogram[V oil.Number](boundaries ...V) *Histogram[V] {
+	b := make([]float64, len(boundaries))
+	for i, boundary := range boundaries {
+		b[i] = toFloat64(boundary)
+	}
+	sort.Float64s(b)
+	return &Histogram[V]{boundaries: b, counts: NewNumMap[int, uint64]()}
+}
+
+// toFloat64 converts any number to a float64, keeping only the real part of
+// complex numbers, which can't be converted directly.
+func toFloat64[V oil.Number](v V) float64 {
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.CanInt():
+		return float64(rv.Int())
+	case rv.CanUint():
+		return float64(rv.Uint())
+	case rv.CanFloat():
+		return rv.Float()
+	}
+	return real(rv.Complex())
+}
+
+// Observe counts a value in its bucket.
+func (h *Histogram[V]) Observe(value float64) {
+	h.counts.Increment(sort.Search(len(h.boundaries), func(i int) bool { return value < h.boundaries[i] }))
+}
+
+// Snapshot returns a copy of the counts of values per bucket index.
+// Buckets that never had any value aren't in the map.
+func (h *Histogram[V]) Snapshot() map[int]uint64 { return h.counts.Snapshot() }
+
+// RateMap tracks the rate of events per key over a rolling time window, and
+// can be used concurrently.
+// The window is split into buckets, and events are counted in the bucket of
+// the time they happen, so the precision of the window is the bucket size.
+type RateMap[K comparable] struct {
+	window     time.Duration
+	buckets    int
+	bucketSize time.Duration
+	now        func() time.Time // time.Now, but tests can replace it
+
+	mu    sync.Mutex // PROTECTS EVERYTHING BELOW
+	rings map[K]*rateRing
+}
+
+// rateRing is a ring buffer of event counts per bucket.
+type rateRing struct {
+	counts []uint64
+	ids    []int64 // id of the bucket counted at the same index in counts
+}
+
+// NewRateMap creates a RateMap tracking the rate of events over a rolling
+// window, which must be positive, split into a number of buckets (1 if it's
+// <= 0).
+func NewRateMap[K comparable](window time.Duration, buckets int) *RateMap[K] {
+	buckets = oil.Max(buckets, 1)
+	return &RateMap[K]{
+		window:     window,
+		buckets:    buckets,
+		bucketSize: oil.Max(window/time.Duration(buckets), 1),
+		now:        time.Now,
+		rings:      make(map[K]*rateRing),
+	}
+}
+
+// Increment counts an event for a key.
+func (rm *RateMap[K]) Increment(key K) {
+	id := rm.bucketID()
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	r := rm.rings[key]
+	if r == nil {
+		r = &rateRing{counts: make([]uint64, rm.buckets), ids: make([]int64, rm.buckets)}
+		rm.rings[key] = r
+	}
+	n := int64(len(r.ids))
+	i := int(((id % n) + n) % n) // id is negative before 1970
+	if r.ids[i] != id {
+		r.ids[i], r.counts[i] = id, 0
+	}
+	r.counts[i]++
+}
+
+// Rate returns the number of events per second for a key over the window.
+func (rm *RateMap[K]) Rate(key K) float64 {
+	id := rm.bucketID()
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	r := rm.rings[key]
+	if r == nil {
+		return 0
+	}
+	var sum uint64
+	for i, count := range r.counts {
+		if id-r.ids[i] < int64(len(r.ids)) {
+			sum += count
+		}
+	}
+	return float64(sum) / rm.window.Seconds()
+}
+
+// Reset forgets the events of a key.
+func (rm *RateMap[K]) Reset(key K) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	delete(rm.rings, key)
+}
+
+// bucketID returns the id of the current bucket, rounding down, so that
+// buckets have the same size before 1970.
+func (rm *RateMap[K]) bucketID() int64 {
+	ns, size := rm.now().UnixNano(), int64(rm.bucketSize)
+	if ns < 0 {
+		return (ns+1)/size - 1
+	}
+	return ns / size
+}
@@ -1,10 +1,15 @@
 package nummap
 
 import (
+	"encoding/json"
+	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/bcogs/golibs/oil"
 )
 
 func do(wg *sync.WaitGroup, f func(k, v int) int, k, v int) {
@@ -68,3 +73,318 @@ func TestNummap(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestCompareAndSwap(t *testing.T) {
+	m := NewNumMap[string, int]()
+	assert.False(t, m.CompareAndSwap("foo", 1, 2))
+	assert.Equal(t, 0, m.Len())
+	assert.True(t, m.CompareAndSwap("foo", 0, 2))
+	assert.Equal(t, 2, m.Get("foo"))
+	assert.False(t, m.CompareAndSwap("foo", 0, 3))
+	assert.True(t, m.CompareAndSwap("foo", 2, 3))
+	assert.Equal(t, 3, m.Get("foo"))
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			for {
+				v := m.Get("foo")
+				if m.CompareAndSwap("foo", v, v+1) {
+					break
+				}
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 103, m.Get("foo"))
+}
+
+func TestIncrementDecrement(t *testing.T) {
+	m := NewNumMap[string, float64]()
+	assert.Equal(t, 1., m.Increment("foo"))
+	assert.Equal(t, 2., m.Increment("foo"))
+	assert.Equal(t, -1., m.Decrement("bar"))
+	assert.Equal(t, 1., m.Decrement("foo"))
+	assert.Equal(t, map[string]float64{"foo": 1, "bar": -1}, m.Snapshot())
+}
+
+func TestGetAndReset(t *testing.T) {
+	m := NewNumMap[string, int]()
+	assert.Equal(t, 0, m.GetAndReset("foo"))
+	assert.Equal(t, 0, m.Len())
+	m.Add("foo", 3)
+	assert.Equal(t, 3, m.GetAndReset("foo"))
+	assert.Equal(t, 0, m.Get("foo"))
+	assert.Equal(t, 1, m.Len())
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	total := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(2)
+		go func() { m.Increment("foo"); wg.Done() }()
+		go func() { v := m.GetAndReset("foo"); mu.Lock(); total += v; mu.Unlock(); wg.Done() }()
+	}
+	wg.Wait()
+	assert.Equal(t, 100, total+m.Get("foo"))
+}
+
+func TestSum(t *testing.T) {
+	m := NewNumMap[string, int]()
+	assert.Equal(t, 0, m.Sum())
+	m.Set("foo", 3)
+	m.Set("bar", -5)
+	m.Set("baz", 10)
+	assert.Equal(t, 8, m.Sum())
+	c := NewNumMap[int, complex64]()
+	c.Set(1, 1+2i)
+	c.Set(2, 3-1i)
+	assert.Equal(t, complex64(4+1i), c.Sum())
+}
+
+func TestMaxMinEntry(t *testing.T) {
+	m := NewNumMap[string, float64]()
+	_, _, ok := MaxEntry(m)
+	assert.False(t, ok)
+	_, _, ok = MinEntry(m)
+	assert.False(t, ok)
+	m.Set("foo", 3)
+	m.Set("bar", -5)
+	m.Set("baz", 10)
+	m.Set("qux", 0)
+	k, v, ok := MaxEntry(m)
+	assert.Equal(t, "baz", k)
+	assert.Equal(t, 10., v)
+	assert.True(t, ok)
+	k, v, ok = MinEntry(m)
+	assert.Equal(t, "bar", k)
+	assert.Equal(t, -5., v)
+	assert.True(t, ok)
+}
+
+func TestFilter(t *testing.T) {
+	m := NewNumMap[string, int]()
+	assert.Equal(t, map[string]int{}, m.Filter(func(string, int) bool { return true }))
+	m.Set("foo", 3)
+	m.Set("bar", -5)
+	m.Set("baz", 10)
+	assert.Equal(t, map[string]int{"foo": 3, "baz": 10}, m.Filter(func(_ string, v int) bool { return v > 0 }))
+	assert.Equal(t, map[string]int{"bar": -5, "baz": 10}, m.Filter(func(k string, _ int) bool { return k[0] == 'b' }))
+	assert.Equal(t, 3, m.Len())
+}
+
+func TestTopN(t *testing.T) {
+	m := NewNumMap[string, int]()
+	assert.Empty(t, TopN(m, 3))
+	m.Set("foo", 3)
+	m.Set("bar", -5)
+	m.Set("baz", 10)
+	m.Set("qux", 0)
+	assert.Equal(t, []oil.Pair[string, int]{oil.NewPair("baz", 10), oil.NewPair("foo", 3)}, TopN(m, 2))
+	assert.Equal(t, []oil.Pair[string, int]{oil.NewPair("baz", 10), oil.NewPair("foo", 3), oil.NewPair("qux", 0), oil.NewPair("bar", -5)}, TopN(m, 10))
+	assert.Empty(t, TopN(m, 0))
+	assert.Empty(t, TopN(m, -1))
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(10., 0., 5.)
+	assert.Equal(t, map[int]uint64{}, h.Snapshot())
+	var wg sync.WaitGroup
+	for _, v := range []float64{-1, 0, 0.5, 4.99, 5, 7, 9.99, 10, 11, 1e9} {
+		wg.Add(1)
+		go func(v float64) { h.Observe(v); wg.Done() }(v)
+	}
+	wg.Wait()
+	assert.Equal(t, map[int]uint64{0: 1, 1: 3, 2: 3, 3: 3}, h.Snapshot())
+	hi := NewHistogram[int]()
+	hi.Observe(42)
+	assert.Equal(t, map[int]uint64{0: 1}, hi.Snapshot())
+	hi = NewHistogram(100, -5)
+	for _, v := range []float64{-5.5, -5, 0.5, 99.9, 100, 1e9} {
+		hi.Observe(v)
+	}
+	assert.Equal(t, map[int]uint64{0: 1, 1: 3, 2: 2}, hi.Snapshot())
+	hc := NewHistogram(complex(1, 5), complex(2, -5))
+	hc.Observe(1.5)
+	hc.Observe(0)
+	assert.Equal(t, map[int]uint64{0: 1, 1: 1}, hc.Snapshot())
+}
+
+func TestMergeFrom(t *testing.T) {
+	m1, m2 := NewNumMap[string, int](), NewNumMap[string, int]()
+	m1.Set("foo", 1)
+	m1.Set("bar", 2)
+	m2.Set("bar", 3)
+	m2.Set("baz", 4)
+	m1.MergeFrom(m2)
+	assert.Equal(t, map[string]int{"foo": 1, "bar": 5, "baz": 4}, m1.Snapshot())
+	assert.Equal(t, map[string]int{"bar": 3, "baz": 4}, m2.Snapshot())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { m1.MergeFrom(m2); wg.Done() }()
+	go func() { m2.MergeFrom(m1); wg.Done() }()
+	wg.Wait()
+	m1.MergeFrom(m1)
+	assert.Equal(t, 3, m1.Len())
+}
+
+func TestClearAndKeys(t *testing.T) {
+	m := NewNumMap[string, int]()
+	assert.Equal(t, []string{}, m.Keys())
+	m.Set("foo", 3)
+	m.Set("bar", 0)
+	assert.ElementsMatch(t, []string{"foo", "bar"}, m.Keys())
+	m.Clear()
+	assert.Equal(t, 0, m.Len())
+	assert.Equal(t, []string{}, m.Keys())
+	assert.Equal(t, 1, m.Increment("foo"))
+}
+
+func TestSetThreshold(t *testing.T) {
+	m := NewNumMap[string, int]()
+	events := make(chan string, 10)
+	SetThreshold(m, "foo", 10, true, func(k string, v int) { events <- fmt.Sprintf("%s above %d", k, v) })
+	SetThreshold(m, "foo", 5, false, func(k string, v int) { events <- fmt.Sprintf("%s below %d", k, v) })
+	m.Add("foo", 9)
+	m.Add("bar", 20)
+	m.Set("foo", 12)
+	m.Sub("foo", 7)
+	assert.Equal(t, "foo below 5", <-events)
+	m.Increment("foo")
+	m.Apply("foo", func(v int) int { return v * 2 })
+	assert.Equal(t, "foo above 12", <-events)
+	m.Add("foo", 1)
+	m.Apply("foo", func(v int) int { return 0 })
+	assert.Equal(t, "foo below 0", <-events)
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %q", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAddMany(t *testing.T) {
+	m := NewNumMap[string, float64]()
+	m.Set("foo", 1)
+	delta := map[string]float64{"foo": 0.5, "bar": -2}
+	m.AddMany(delta)
+	assert.Equal(t, map[string]float64{"foo": 1.5, "bar": -2}, m.Snapshot())
+	assert.Equal(t, map[string]float64{"foo": 0.5, "bar": -2}, delta)
+	m.AddMany(nil)
+	assert.Equal(t, 2, m.Len())
+}
+
+func TestSetIfGreaterLess(t *testing.T) {
+	m := NewNumMap[string, int]()
+	assert.False(t, SetIfGreater(m, "max", -1))
+	assert.Equal(t, 0, m.Len())
+	assert.True(t, SetIfGreater(m, "max", 3))
+	assert.False(t, SetIfGreater(m, "max", 3))
+	assert.False(t, SetIfGreater(m, "max", 2))
+	assert.True(t, SetIfGreater(m, "max", 7))
+	assert.True(t, SetIfLess(m, "min", -1))
+	assert.False(t, SetIfLess(m, "min", 5))
+	assert.True(t, SetIfLess(m, "min", -4))
+	assert.Equal(t, map[string]int{"max": 7, "min": -4}, m.Snapshot())
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) { SetIfGreater(m, "max", i); wg.Done() }(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 99, m.Get("max"))
+}
+
+func TestJSON(t *testing.T) {
+	m := NewNumMap[string, float64]()
+	m.Set("foo", 1.5)
+	m.Set("bar", -2)
+	b, err := json.Marshal(m)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"foo": 1.5, "bar": -2}`, string(b))
+	m2 := NewNumMap[string, float64]()
+	m2.Set("baz", 3)
+	assert.NoError(t, json.Unmarshal(b, m2))
+	assert.Equal(t, m.Snapshot(), m2.Snapshot())
+	assert.Error(t, json.Unmarshal([]byte(`{"foo": "x"}`), m2))
+	assert.Equal(t, m.Snapshot(), m2.Snapshot())
+	var m3 NumMap[int, uint]
+	assert.NoError(t, json.Unmarshal([]byte(`{"1": 2}`), &m3))
+	assert.Equal(t, uint(3), m3.Add(1, 1))
+	c := NewNumMap[int, complex64]()
+	c.Set(1, 2i)
+	_, err = json.Marshal(c)
+	assert.Error(t, err)
+}
+
+func TestGetOrSet(t *testing.T) {
+	m := NewNumMap[string, int]()
+	assert.Equal(t, 3, m.GetOrSet("foo", 3))
+	assert.Equal(t, 3, m.GetOrSet("foo", 4))
+	m.Set("bar", 0)
+	assert.Equal(t, 0, m.GetOrSet("bar", 5))
+	assert.Equal(t, map[string]int{"foo": 3, "bar": 0}, m.Snapshot())
+}
+
+func TestMultiApply(t *testing.T) {
+	m := NewNumMap[string, int]()
+	m.Set("foo", 1)
+	m.Set("bar", 2)
+	m.Set("baz", 3)
+	calls := 0
+	m.MultiApply([]string{"foo", "bar", "foo", "qux"}, func(v int) int { calls++; return v*10 + 1 })
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, map[string]int{"foo": 11, "bar": 21, "baz": 3, "qux": 1}, m.Snapshot())
+	m.MultiApply(nil, func(v int) int { panic("unexpected call") })
+}
+
+func TestRateMap(t *testing.T) {
+	now := time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC)
+	rm := NewRateMap[string](10*time.Second, 5)
+	rm.now = func() time.Time { return now }
+	assert.Equal(t, 0.0, rm.Rate("foo"))
+	for i := 0; i < 20; i++ {
+		rm.Increment("foo")
+	}
+	rm.Increment("bar")
+	assert.Equal(t, 2.0, rm.Rate("foo"))
+	assert.Equal(t, 0.1, rm.Rate("bar"))
+	now = now.Add(4 * time.Second)
+	for i := 0; i < 10; i++ {
+		rm.Increment("foo")
+	}
+	assert.Equal(t, 3.0, rm.Rate("foo"))
+	now = now.Add(6 * time.Second) // the first 20 events are out of the window
+	assert.Equal(t, 1.0, rm.Rate("foo"))
+	assert.Equal(t, 0.0, rm.Rate("bar"))
+	rm.Increment("foo")
+	assert.Equal(t, 1.1, rm.Rate("foo"))
+	now = now.Add(time.Hour)
+	assert.Equal(t, 0.0, rm.Rate("foo"))
+	rm.Increment("foo")
+	assert.Equal(t, 0.1, rm.Rate("foo"))
+	rm.Reset("foo")
+	assert.Equal(t, 0.0, rm.Rate("foo"))
+	rm.Increment("foo")
+	assert.Equal(t, 0.1, rm.Rate("foo"))
+
+	rm = NewRateMap[string](time.Second, 0)
+	rm.Increment("foo")
+	assert.Equal(t, 1.0, rm.Rate("foo"))
+
+	now = time.Date(1969, 12, 31, 23, 59, 52, 0, time.UTC)
+	rm = NewRateMap[string](10*time.Second, 5)
+	rm.now = func() time.Time { return now }
+	for i := 0; i < 20; i++ {
+		rm.Increment("foo")
+	}
+	assert.Equal(t, 2.0, rm.Rate("foo"))
+	now = now.Add(7 * time.Second) // just before the epoch
+	rm.Increment("foo")
+	assert.Equal(t, 2.1, rm.Rate("foo"))
+	now = now.Add(2 * time.Second) // just after the epoch
+	rm.Increment("foo")
+	assert.Equal(t, 2.2, rm.Rate("foo"))
+	now = now.Add(2 * time.Second) // the first 20 events are out of the window
+	assert.Equal(t, 0.2, rm.Rate("foo"))
+}
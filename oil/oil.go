@@ -5,9 +5,18 @@
 package oil
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/exp/constraints"
 )
@@ -32,6 +41,9 @@ func Abs[T OrderedNumber](x T) T {
 
 // If mimics the C ternary operator ("?").
 // It returns ifTrue or ifFalse depending on the value of a boolean.
+// Unlike with the C operator, both ifTrue and ifFalse are evaluated before the
+// call, whatever the value of the boolean; use IfFunc if that's a problem, e.g.
+// if one of them is expensive to compute or could panic.
 func If[T any](b bool, ifTrue, ifFalse T) T {
 	if b {
 		return ifTrue
@@ -39,6 +51,15 @@ func If[T any](b bool, ifTrue, ifFalse T) T {
 	return ifFalse
 }
 
+// IfFunc is a lazy If: it calls ifTrue or ifFalse depending on the value of a
+// boolean, and returns the result.  The other function isn't called.
+func IfFunc[T any](b bool, ifTrue, ifFalse func() T) T {
+	if b {
+		return ifTrue()
+	}
+	return ifFalse()
+}
+
 // Max returns the max of two ordered numbers.
 func Max[T constraints.Ordered](a, b T) T {
 	if a > b {
@@ -55,6 +76,15 @@ func Min[T constraints.Ordered](a, b T) T {
 	return b
 }
 
+// SafeDiv returns a divided by b, or whenZero if b is 0, including for floats,
+// for which it avoids returning infinities or NaN.
+func SafeDiv[T OrderedNumber](a, b, whenZero T) T {
+	if b == 0 {
+		return whenZero
+	}
+	return a / b
+}
+
 // Atoi parses an integer value, verifies that it's between min and max, and if
 // there's a parse error or it's out of bounds, returns an error message that
 // looks like: invalid $whatIsIt blah blah
@@ -174,6 +204,21 @@ func (o *Optional[T]) Unset() *Optional[T] {
 	return o
 }
 
+// Result holds either a value or an error, e.g. to send both down a channel.
+type Result[T any] struct {
+	Value T
+	Err   error
+}
+
+// Ok creates a Result holding a value.
+func Ok[T any](val T) Result[T] { return Result[T]{Value: val} }
+
+// Err creates a Result holding an error; T must be specified, e.g. Err[int](err).
+func Err[T any](err error) Result[T] { return Result[T]{Err: err} }
+
+// Unwrap returns the value and the error of a Result.
+func (r Result[T]) Unwrap() (T, error) { return r.Value, r.Err }
+
 // MapGet gets a value from a map and returns a default if the map doens't have the specified key.
 func MapGet[K comparable, V any](m map[K]V, key K, defaultValue V) V {
 	if v, ok := m[key]; ok {
@@ -182,6 +227,24 @@ func MapGet[K comparable, V any](m map[K]V, key K, defaultValue V) V {
 	return defaultValue
 }
 
+// MapMustGet gets a value from a map and returns an error if the map doesn't have the specified key.
+func MapMustGet[K comparable, V any](m map[K]V, key K) (V, error) {
+	v, ok := m[key]
+	if !ok {
+		return v, fmt.Errorf("key %#v not found in %T", key, m)
+	}
+	return v, nil
+}
+
+// MapMustGetOrPanic gets a value from a map and panics if the map doesn't have the specified key.
+func MapMustGetOrPanic[K comparable, V any](m map[K]V, key K) V {
+	v, err := MapMustGet(m, key)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
 // MapSetDefault sets a value in a map for a given key, if that key isn't in the map already.  It returns the map itself.
 func MapSetDefault[K comparable, V any](m map[K]V, key K, value V) map[K]V {
 	if _, ok := m[key]; !ok {
@@ -221,6 +284,101 @@ func MapFromSlice[K comparable, V any](slice []K, value V) map[K]V {
 	return m
 }
 
+// MergeMaps copies the entries of a map src into a map dst and returns dst,
+// calling resolve to compute the value of keys that are in both maps.
+// If dst is nil, a new map is created.
+func MergeMaps[K comparable, V any](dst, src map[K]V, resolve func(existing, incoming V) V) map[K]V {
+	if dst == nil {
+		dst = make(map[K]V, len(src))
+	}
+	for k, v := range src {
+		if existing, ok := dst[k]; ok {
+			v = resolve(existing, v)
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// MergeMapsOverwrite is MergeMaps with the values of src overwriting those of dst.
+func MergeMapsOverwrite[K comparable, V any](dst, src map[K]V) map[K]V {
+	return MergeMaps(dst, src, func(_, incoming V) V { return incoming })
+}
+
+// Difference returns the elements of a slice a that aren't in a slice b,
+// without duplicates, in the order of a.
+func Difference[T comparable](a, b []T) []T {
+	exclude := MapFromSlice(b, struct{}{})
+	return appendUnique(make([]T, 0), a, func(x T) bool { _, ok := exclude[x]; return !ok })
+}
+
+// Intersection returns the elements of a slice a that are also in a slice b,
+// without duplicates, in the order of a.
+func Intersection[T comparable](a, b []T) []T {
+	include := MapFromSlice(b, struct{}{})
+	return appendUnique(make([]T, 0), a, func(x T) bool { _, ok := include[x]; return ok })
+}
+
+// Union returns the elements of two slices, without duplicates, in the order
+// of a followed by the elements of b that aren't in a.
+func Union[T comparable](a, b []T) []T {
+	// the full slice expression makes append copy a instead of overwriting what follows it
+	return appendUnique(make([]T, 0, len(a)+len(b)), append(a[:len(a):len(a)], b...), func(T) bool { return true })
+}
+
+// appendUnique appends to a slice the elements of another for which keep
+// returns true, skipping duplicates.
+func appendUnique[T comparable](dst, src []T, keep func(T) bool) []T {
+	seen := make(map[T]struct{}, len(src))
+	for _, x := range src {
+		if _, dup := seen[x]; !dup && keep(x) {
+			seen[x] = struct{}{}
+			dst = append(dst, x)
+		}
+	}
+	return dst
+}
+
+// DerefSlice returns the values pointed to by a slice of pointers, with zero
+// values for nil pointers, so indices are preserved.
+func DerefSlice[T any](s []*T) []T {
+	result := make([]T, len(s))
+	for i, p := range s {
+		if p != nil {
+			result[i] = *p
+		}
+	}
+	return result
+}
+
+// RefSlice returns pointers to copies of the elements of a slice, so modifying
+// the pointed values doesn't modify the slice.
+func RefSlice[T any](s []T) []*T {
+	values, result := append([]T{}, s...), make([]*T, len(s))
+	for i := range values {
+		result[i] = &values[i]
+	}
+	return result
+}
+
+// InsertSorted inserts a value in a sorted slice, after the elements equal to
+// it, so the slice stays sorted, and returns the grown slice, which reuses the
+// slice's capacity if possible, like append.
+func InsertSorted[T constraints.Ordered](s []T, v T) []T {
+	return InsertSortedFunc(s, v, func(a, b T) bool { return a < b })
+}
+
+// InsertSortedFunc is like InsertSorted for a slice sorted according to a less
+// function.
+func InsertSortedFunc[T any](s []T, v T, less func(a, b T) bool) []T {
+	i := sort.Search(len(s), func(i int) bool { return less(v, s[i]) })
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
 // FanIn writes anything it reads from a number of channels, the producers, to a single channel, the consumer.
 // If all the producers get closed, it closes the consumer and returns.
 // Whenever there's a write to a producer, the consumer must be read, otherwise, FanIn could get stuck.
@@ -252,3 +410,675 @@ func FanOut[T any](producer <-chan T, consumers ...chan<- T) {
 		close(consumer)
 	}
 }
+
+// ErrGroup runs functions in goroutines, waits for them to complete, and
+// collects the first error they return.
+// It's a lighter version of golang.org/x/sync/errgroup, without contexts.
+// The zero value is an ErrGroup that doesn't bound concurrency.
+type ErrGroup struct {
+	wg   sync.WaitGroup
+	sem  chan struct{} // nil if concurrency isn't bounded
+	once sync.Once
+	err  error
+}
+
+// NewErrGroup creates an ErrGroup that runs at most maxConcurrency functions
+// at the same time, or doesn't bound concurrency if maxConcurrency is <= 0.
+func NewErrGroup(maxConcurrency int) *ErrGroup {
+	g := &ErrGroup{}
+	if maxConcurrency > 0 {
+		g.sem = make(chan struct{}, maxConcurrency)
+	}
+	return g
+}
+
+// Go runs a function in a new goroutine.
+// If concurrency is bounded and the maximum number of functions is already
+// running, it blocks until one of them returns.
+func (g *ErrGroup) Go(f func() error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if g.sem != nil {
+			defer func() { <-g.sem }()
+		}
+		if err := f(); err != nil {
+			g.once.Do(func() { g.err = err })
+		}
+	}()
+}
+
+// Wait waits for all the functions passed to Go to return, and returns the
+// first non-nil error they returned, if any.
+func (g *ErrGroup) Wait() error {
+	g.wg.Wait()
+	return g.err
+}
+
+// Try calls a function and converts any panic into an error.
+// If the panic value is an error, the returned error wraps it, so it can be
+// inspected with errors.Is and errors.As.
+func Try(f func()) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = panicToError(p)
+		}
+	}()
+	f()
+	return nil
+}
+
+// Try1 is the same as Try, for a function that returns a value.
+func Try1[T any](f func() T) (result T, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = panicToError(p)
+		}
+	}()
+	return f(), nil
+}
+
+func panicToError(p any) error {
+	if err, ok := p.(error); ok {
+		return fmt.Errorf("panic: %w", err)
+	}
+	return fmt.Errorf("panic: %v", p)
+}
+
+// IsZero tells if a value is the zero value of its type.
+// Use IsZeroReflect for types that aren't comparable.
+func IsZero[T comparable](v T) bool {
+	var zero T
+	return v == zero
+}
+
+// IsZeroReflect tells if a value is the zero value of its type, like IsZero,
+// but it works with any type, including non comparable structs, at the cost of
+// using reflection.  A nil interface is considered zero.
+func IsZeroReflect(v any) bool {
+	return v == nil || reflect.ValueOf(v).IsZero()
+}
+
+// NonZero returns a new slice with the non zero elements of a slice.
+func NonZero[T comparable](s []T) []T {
+	result := make([]T, 0, len(s))
+	for _, v := range s {
+		if !IsZero(v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// MustNotNil returns v, or panics if it's nil, i.e. if it's a nil pointer,
+// interface, map, slice, channel or function; values of other types never
+// make it panic.  name appears in the panic message.
+// It's meant to check dependencies at initialization time, to catch
+// misconfigurations early, not for regular error handling.
+func MustNotNil[T any](v T, name string) T {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		panic(fmt.Sprintf("%s is nil", name))
+	}
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		if rv.IsNil() {
+			panic(fmt.Sprintf("%s is a nil %T", name, v))
+		}
+	}
+	return v
+}
+
+// TopoSort sorts nodes topologically: each node comes after all the nodes it
+// depends on, according to a map of each node to its dependencies.
+// Dependencies that aren't in nodes are included in the result too.
+// The order is deterministic: it follows the order of nodes and dependencies
+// as much as possible.
+// If there's a dependency cycle, it returns an error that lists its nodes.
+func TopoSort[T comparable](nodes []T, deps map[T][]T) ([]T, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	result, state, path := make([]T, 0, len(nodes)), make(map[T]int), []T{}
+	var visit func(n T) error
+	visit = func(n T) error {
+		switch state[n] {
+		case visited:
+			return nil
+		case visiting:
+			k := len(path) - 1
+			for path[k] != n {
+				k--
+			}
+			s := ""
+			for _, x := range append(path[k:], n) {
+				s += fmt.Sprintf(" -> %v", x)
+			}
+			return fmt.Errorf("dependency cycle: %s", s[4:])
+		}
+		state[n], path = visiting, append(path, n)
+		for _, d := range deps[n] {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		state[n], path = visited, path[:len(path)-1]
+		result = append(result, n)
+		return nil
+	}
+	for _, n := range nodes {
+		if err := visit(n); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// WithOptions applies functional options to a copy of a base value, in order,
+// and returns the copy.  The base value itself isn't modified (but beware that
+// it's a shallow copy: maps or slices in the base value are shared).
+// Example use, to implement the functional options pattern:
+//
+//	func NewServer(opts ...func(*Config)) *Server {
+//		config := oil.WithOptions(defaultConfig, opts...)
+//		...
+//	}
+//	s := NewServer(func(c *Config) { c.Port = 8080 })
+func WithOptions[T any](base T, opts ...func(*T)) T {
+	for _, opt := range opts {
+		opt(&base)
+	}
+	return base
+}
+
+// TimedMemoize returns a function that returns the same result as f, but caches
+// the result for each argument, and calls f again only when the cached result
+// is older than ttl, according to now (time.Now if it's nil).
+// The returned function can be called concurrently; if it's called
+// concurrently for the same argument without a valid cached result, f may be
+// called more than once.  Results are never evicted, only replaced, so the
+// cache grows with the number of distinct arguments.
+func TimedMemoize[K comparable, V any](now func() time.Time, ttl time.Duration, f func(K) V) func(K) V {
+	if now == nil {
+		now = time.Now
+	}
+	type entry struct {
+		v       V
+		created time.Time
+	}
+	var mu sync.Mutex
+	cache := make(map[K]entry)
+	return func(k K) V {
+		mu.Lock()
+		e, ok := cache[k]
+		mu.Unlock()
+		if ok && now().Sub(e.created) < ttl {
+			return e.v
+		}
+		e = entry{v: f(k), created: now()}
+		mu.Lock()
+		cache[k] = e
+		mu.Unlock()
+		return e.v
+	}
+}
+
+// CoalesceChan returns a channel forwarding the values received from in, but
+// only once no new value has been received for a quiet period, dropping the
+// values superseded during that period, which after (time.After if it's nil)
+// measures.
+// When in is closed, the pending value, if any, is forwarded immediately, then
+// the returned channel is closed.
+func CoalesceChan[T any](in <-chan T, quiet time.Duration, after func(time.Duration) <-chan time.Time) <-chan T {
+	if after == nil {
+		after = time.After
+	}
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		var pending T
+		var timer <-chan time.Time // nil when there's no pending value
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					if timer != nil {
+						out <- pending
+					}
+					return
+				}
+				pending, timer = v, after(quiet)
+			case <-timer:
+				out <- pending
+				timer = nil
+			}
+		}
+	}()
+	return out
+}
+
+// Range returns the integers from start included to stop excluded, by steps of
+// step, like Python's range, e.g. Range(0, 10, 3) returns [0 3 6 9] and
+// Range(3, 0, -1) returns [3 2 1].
+// It panics if step is 0.
+func Range[T constraints.Integer](start, stop, step T) []T {
+	var r []T
+	RangeFunc(start, stop, step, func(i T) { r = append(r, i) })
+	if r == nil {
+		return []T{}
+	}
+	return r
+}
+
+// RangeFunc is like Range, but calls a function for each integer instead of
+// returning a slice.
+// It panics if step is 0.
+func RangeFunc[T constraints.Integer](start, stop, step T, f func(T)) {
+	switch {
+	case step > 0:
+		for i := start; i < stop; {
+			f(i)
+			if next := i + step; next > i { // otherwise it wrapped around
+				i = next
+			} else {
+				break
+			}
+		}
+	case step < 0:
+		for i := start; i > stop; {
+			f(i)
+			if next := i + step; next < i {
+				i = next
+			} else {
+				break
+			}
+		}
+	default:
+		panic("oil.RangeFunc: step is 0")
+	}
+}
+
+// Sampler decides whether to sample events, e.g. to log only some of them,
+// and can be used concurrently.
+type Sampler struct {
+	n     uint64 // sample every n calls if > 0, otherwise with probability p
+	calls atomic.Uint64
+
+	p   float64
+	mu  sync.Mutex // protects rnd
+	rnd *rand.Rand
+}
+
+// NewEveryNSampler creates a Sampler that samples exactly one call out of n,
+// the nth, 2nth, 3nth etc, or never if n is 0.
+func NewEveryNSampler(n uint64) *Sampler {
+	if n == 0 {
+		return NewRandomSampler(0, 0)
+	}
+	return &Sampler{n: n}
+}
+
+// NewRandomSampler creates a Sampler that samples each call with probability
+// p, using a pseudo-random generator seeded with seed, so the sequence of
+// decisions is reproducible (if calls aren't concurrent).
+func NewRandomSampler(p float64, seed int64) *Sampler {
+	return &Sampler{p: p, rnd: rand.New(rand.NewSource(seed))}
+}
+
+// ShouldSample returns whether to sample the event this call is made for.
+func (s *Sampler) ShouldSample() bool {
+	if s.n > 0 {
+		return s.calls.Add(1)%s.n == 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rnd.Float64() < s.p
+}
+
+// EMA computes an exponential moving average of samples, and can be used
+// concurrently.
+type EMA struct {
+	alpha float64
+
+	mu      sync.Mutex // PROTECTS EVERYTHING BELOW
+	value   float64
+	started bool
+}
+
+// NewEMA creates an EMA where each new sample weighs alpha, between 0 and 1,
+// and the previous average weighs 1-alpha.
+func NewEMA(alpha float64) *EMA { return &EMA{alpha: alpha} }
+
+// Add adds a sample and returns the updated average.
+// The first sample initializes the average.
+func (e *EMA) Add(sample float64) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.started {
+		e.value += e.alpha * (sample - e.value)
+	} else {
+		e.value, e.started = sample, true
+	}
+	return e.value
+}
+
+// Value returns the current average, or 0 if there's no sample yet.
+func (e *EMA) Value() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.value
+}
+
+// BatchChan returns a channel sending the values received from in in batches
+// of maxBatch values (at least 1), or fewer if no new value has been received
+// for maxWait, as timed by after (time.After if it's nil).
+// When in is closed, the pending batch, if any, is sent immediately, then the
+// returned channel is closed.
+func BatchChan[T any](in <-chan T, maxBatch int, maxWait time.Duration, after func(time.Duration) <-chan time.Time) <-chan []T {
+	if after == nil {
+		after = time.After
+	}
+	maxBatch = Max(maxBatch, 1)
+	out := make(chan []T)
+	go func() {
+		defer close(out)
+		var batch []T
+		var timer <-chan time.Time // nil when the batch is empty
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					if len(batch) > 0 {
+						out <- batch
+					}
+					return
+				}
+				if batch = append(batch, v); len(batch) >= maxBatch {
+					out <- batch
+					batch, timer = nil, nil
+				} else {
+					timer = after(maxWait)
+				}
+			case <-timer:
+				out <- batch
+				batch, timer = nil, nil
+			}
+		}
+	}()
+	return out
+}
+
+// KeyedDebouncer allows something at most once per interval for each key,
+// e.g. fetching a URL, and can be used concurrently.
+// Expired keys are pruned lazily, at most once per interval.
+type KeyedDebouncer[K comparable] struct {
+	interval time.Duration
+	now      func() time.Time
+
+	mu        sync.Mutex // PROTECTS EVERYTHING BELOW
+	allowed   map[K]time.Time
+	nextPrune time.Time
+}
+
+// NewKeyedDebouncer creates a KeyedDebouncer that reads the time with now, or
+// time.Now if it's nil.
+func NewKeyedDebouncer[K comparable](interval time.Duration, now func() time.Time) *KeyedDebouncer[K] {
+	if now == nil {
+		now = time.Now
+	}
+	return &KeyedDebouncer[K]{interval: interval, now: now, allowed: make(map[K]time.Time)}
+}
+
+// Allow returns true if it didn't for the same key in the last interval.
+func (kd *KeyedDebouncer[K]) Allow(key K) bool {
+	now := kd.now()
+	kd.mu.Lock()
+	defer kd.mu.Unlock()
+	if !now.Before(kd.nextPrune) {
+		for k, t := range kd.allowed {
+			if now.Sub(t) >= kd.interval {
+				delete(kd.allowed, k)
+			}
+		}
+		kd.nextPrune = now.Add(kd.interval)
+	}
+	if t, ok := kd.allowed[key]; ok && now.Sub(t) < kd.interval {
+		return false
+	}
+	kd.allowed[key] = now
+	return true
+}
+
+// SplitBytes splits data in records terminated by a separator: unlike
+// bytes.Split, a trailing separator doesn't create an empty last record, and
+// empty data has no records, but leading or consecutive separators create
+// empty records, and the last record doesn't need a trailing separator.
+// The records are subslices of data.
+func SplitBytes(data []byte, sep byte) [][]byte {
+	records := [][]byte{}
+	for len(data) > 0 {
+		k := bytes.IndexByte(data, sep)
+		if k < 0 {
+			return append(records, data)
+		}
+		records, data = append(records, data[:k:k]), data[k+1:]
+	}
+	return records
+}
+
+// ScanDelimited reads records terminated by a separator from a reader, like
+// SplitBytes splits them, and calls a function with each of them, without
+// the separator, until the reader returns io.EOF or an error, or the function
+// returns an error, which ScanDelimited then returns.
+// The record passed to the function is only valid during the call.
+// Records longer than maxRecord bytes make it fail with an error wrapping
+// bufio.ErrTooLong, and a negative maxRecord makes it fail right away.
+func ScanDelimited(r io.Reader, sep byte, maxRecord int, fn func([]byte) error) error {
+	if maxRecord < 0 {
+		return fmt.Errorf("scanning records failed - negative maximum record length %d", maxRecord)
+	}
+	maxBuf := If(maxRecord < math.MaxInt, maxRecord+1, maxRecord) // room for the separator
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, Min(maxBuf, 4096)), maxBuf)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if k := bytes.IndexByte(data, sep); k >= 0 {
+			return k + 1, data[:k], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	for scanner.Scan() {
+		if err := fn(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("scanning records failed - %w", err)
+	}
+	return nil
+}
+
+var (
+	defaultRndMu sync.Mutex // protects defaultRnd
+	defaultRnd   = rand.New(rand.NewSource(1))
+)
+
+// WeightedChoice returns one of some items, chosen randomly with a probability
+// proportional to its weight, using a pseudo-random generator, or if it's nil,
+// a package default generator with a fixed seed, so a program making the same
+// calls gets the same choices on every run.
+// It returns an error if there isn't one weight per item, if a weight is
+// negative or NaN, or if the weights don't have a positive finite sum.
+func WeightedChoice[T any](items []T, weights []float64, rnd *rand.Rand) (T, error) {
+	var zero T
+	if len(items) != len(weights) {
+		return zero, fmt.Errorf("weighted choice between %d items with %d weights", len(items), len(weights))
+	}
+	sum := 0.0
+	for i, w := range weights {
+		if !(w >= 0) {
+			return zero, fmt.Errorf("invalid weight %v for item %d of weighted choice", w, i)
+		}
+		sum += w
+	}
+	if !(sum > 0) || math.IsInf(sum, 0) {
+		return zero, fmt.Errorf("invalid sum of weights %v for weighted choice", sum)
+	}
+	var x float64
+	if rnd != nil {
+		x = sum * rnd.Float64()
+	} else {
+		defaultRndMu.Lock()
+		x = sum * defaultRnd.Float64()
+		defaultRndMu.Unlock()
+	}
+	last := 0
+	for i, w := range weights {
+		if w > 0 {
+			if x < w {
+				return items[i], nil
+			}
+			x, last = x-w, i
+		}
+	}
+	return items[last], nil // rounding errors made x >= the sum
+}
+
+// StructToMap returns a map with the exported fields of a struct, or of the
+// struct a pointer points to, or nil if v is neither.
+// The keys are the field names, unless a field has an `oil:"key"` tag, and
+// fields tagged `oil:"-"` and unexported fields are skipped.
+func StructToMap(v any) map[string]any {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	m := make(map[string]any, rv.NumField())
+	for i := 0; i < rv.NumField(); i++ {
+		if key, ok := structFieldKey(rv.Type().Field(i)); ok {
+			m[key] = rv.Field(i).Interface()
+		}
+	}
+	return m
+}
+
+// MapToStruct sets the fields of the struct v points to from the values of a
+// map, with the same keys and tags as StructToMap; fields without a key in
+// the map are left unchanged, and keys without a field are ignored.
+// A value must be assignable to its field, except numbers, which are
+// converted to the type of numeric fields, e.g. the float64 of unmarshaled
+// json to an int, as long as the field can hold their exact value, and nil,
+// which sets the field to its zero value.
+func MapToStruct(m map[string]any, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("can't set the fields of %T, it isn't a non-nil pointer to a struct", v)
+	}
+	rv = rv.Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		key, ok := structFieldKey(rv.Type().Field(i))
+		if !ok {
+			continue
+		}
+		x, ok := m[key]
+		if !ok {
+			continue
+		}
+		field := rv.Field(i)
+		if x == nil {
+			field.Set(reflect.Zero(field.Type()))
+			continue
+		}
+		xv := reflect.ValueOf(x)
+		switch {
+		case xv.Type().AssignableTo(field.Type()):
+			field.Set(xv)
+		case isNumberKind(xv.Kind()) && isNumberKind(field.Kind()):
+			if err := setNumber(field, xv); err != nil {
+				return fmt.Errorf("can't set field %s of %T to %v, a %T - %w", rv.Type().Field(i).Name, v, x, x, err)
+			}
+		default:
+			return fmt.Errorf("can't set field %s of %T to %v, a %T", rv.Type().Field(i).Name, v, x, x)
+		}
+	}
+	return nil
+}
+
+// structFieldKey returns the map key of a struct field for StructToMap and
+// MapToStruct, and false if the field is skipped.
+func structFieldKey(f reflect.StructField) (string, bool) {
+	if !f.IsExported() {
+		return "", false
+	}
+	switch tag := f.Tag.Get("oil"); tag {
+	case "-":
+		return "", false
+	case "":
+		return f.Name, true
+	default:
+		return tag, true
+	}
+}
+
+// setNumber sets a numeric field to a number of another type, or returns an
+// error if the field can't hold its exact value.
+func setNumber(field, x reflect.Value) error {
+	switch field.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f, exact := x.Convert(field.Type()).Float(), false
+		switch x.Kind() {
+		case reflect.Float32, reflect.Float64:
+			exact = f == x.Float() || (math.IsNaN(f) && math.IsNaN(x.Float()))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			exact = f < math.MaxUint64 && uint64(f) == x.Uint()
+		default:
+			exact = f >= math.MinInt64 && f < math.MaxInt64 && int64(f) == x.Int()
+		}
+		if !exact {
+			return fmt.Errorf("%v can't be represented exactly by %s", x, field.Type())
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch x.Kind() {
+		case reflect.Float32, reflect.Float64:
+			if f := x.Float(); f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 || field.OverflowInt(int64(f)) {
+				return fmt.Errorf("%v isn't an integer that fits in %s", x, field.Type())
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if u := x.Uint(); u > math.MaxInt64 || field.OverflowInt(int64(u)) {
+				return fmt.Errorf("%v overflows %s", x, field.Type())
+			}
+		default:
+			if field.OverflowInt(x.Int()) {
+				return fmt.Errorf("%v overflows %s", x, field.Type())
+			}
+		}
+	default: // unsigned integers
+		switch x.Kind() {
+		case reflect.Float32, reflect.Float64:
+			if f := x.Float(); f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 || field.OverflowUint(uint64(f)) {
+				return fmt.Errorf("%v isn't a non-negative integer that fits in %s", x, field.Type())
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if field.OverflowUint(x.Uint()) {
+				return fmt.Errorf("%v overflows %s", x, field.Type())
+			}
+		default:
+			if i := x.Int(); i < 0 || field.OverflowUint(uint64(i)) {
+				return fmt.Errorf("%v is negative or overflows %s", x, field.Type())
+			}
+		}
+	}
+	field.Set(x.Convert(field.Type()))
+	return nil
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	return (k >= reflect.Int && k <= reflect.Uintptr) || k == reflect.Float32 || k == reflect.Float64
+}
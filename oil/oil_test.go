@@ -1,7 +1,15 @@
 package oil_test
 
 import (
+	"bufio"
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -20,6 +28,17 @@ func TestIf(t *testing.T) {
 	assert.Equal(t, 1, oil.If(true, 1, 0))
 }
 
+func TestIfFunc(t *testing.T) {
+	var called []string
+	f := func(s string) func() string { return func() string { called = append(called, s); return s } }
+	assert.Equal(t, "a", oil.IfFunc(true, f("a"), f("b")))
+	assert.Equal(t, []string{"a"}, called)
+	s := []int{}
+	assert.Equal(t, -1, oil.IfFunc(len(s) > 0, func() int { return s[0] }, func() int { return -1 }))
+	assert.Equal(t, "b", oil.IfFunc(false, f("a"), f("b")))
+	assert.Equal(t, []string{"a", "b"}, called)
+}
+
 func TestMax(t *testing.T) {
 	assert.Equal(t, int64(-4), oil.Max(int64(-8), int64(-4)))
 	assert.Equal(t, 3.2, oil.Max(3.2, 1.))
@@ -133,6 +152,19 @@ func TestMapDefaults(t *testing.T) {
 	assert.Equal(t, m, m2)
 }
 
+func TestMapMustGet(t *testing.T) {
+	m := map[string]int{"foo": 0}
+	v, err := oil.MapMustGet(m, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, v)
+	_, err = oil.MapMustGet(m, "bar")
+	assert.ErrorContains(t, err, `"bar"`)
+	_, err = oil.MapMustGet(map[int]int{}, 42)
+	assert.ErrorContains(t, err, "42")
+	assert.Equal(t, 0, oil.MapMustGetOrPanic(m, "foo"))
+	assert.Panics(t, func() { oil.MapMustGetOrPanic(m, "bar") })
+}
+
 func TestMapGetOrNew(t *testing.T) {
 	m := map[int]int{1: 2}
 	assert.Equal(t, 2, oil.MapGetOrNew(m, 1, func() int { return 3 }))
@@ -191,3 +223,662 @@ func TestFanOut(t *testing.T) {
 	_, ok = <-consumer2
 	assert.False(t, ok)
 }
+
+func TestErrGroup(t *testing.T) {
+	var g oil.ErrGroup
+	var n int32
+	for i := 0; i < 10; i++ {
+		g.Go(func() error { atomic.AddInt32(&n, 1); return nil })
+	}
+	assert.NoError(t, g.Wait())
+	assert.Equal(t, int32(10), n)
+
+	first := errors.New("first")
+	g2 := oil.NewErrGroup(1) // runs the functions one at a time, in order
+	for _, err := range []error{nil, nil, first, nil, errors.New("second"), errors.New("third"), nil, nil, nil, nil} {
+		err := err
+		g2.Go(func() error { atomic.AddInt32(&n, 1); return err })
+	}
+	assert.Equal(t, first, g2.Wait())
+	assert.Equal(t, int32(20), n)
+}
+
+func TestErrGroupMaxConcurrency(t *testing.T) {
+	const max = 3
+	var running, maxRunning int32
+	var mu sync.Mutex
+	g := oil.NewErrGroup(max)
+	for i := 0; i < 20; i++ {
+		g.Go(func() error {
+			r := atomic.AddInt32(&running, 1)
+			mu.Lock()
+			maxRunning = oil.Max(maxRunning, r)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil
+		})
+	}
+	assert.NoError(t, g.Wait())
+	assert.LessOrEqual(t, maxRunning, int32(max))
+}
+
+func TestTry(t *testing.T) {
+	assert.NoError(t, oil.Try(func() {}))
+	errz := errors.New("fake error")
+	err := oil.Try(func() { panic(errz) })
+	assert.ErrorIs(t, err, errz)
+	err = oil.Try(func() { panic("foo") })
+	assert.ErrorContains(t, err, "foo")
+	err = oil.Try(func() { _ = []int{}[3] })
+	assert.ErrorContains(t, err, "index out of range")
+
+	n, err := oil.Try1(func() int { return 3 })
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = oil.Try1(func() int { panic(errz) })
+	assert.ErrorIs(t, err, errz)
+	assert.Equal(t, 0, n)
+	_, err = oil.Try1(func() string { panic("bar") })
+	assert.ErrorContains(t, err, "bar")
+}
+
+func TestIsZero(t *testing.T) {
+	one := 1
+	assert.True(t, oil.IsZero(0))
+	assert.False(t, oil.IsZero(-1))
+	assert.True(t, oil.IsZero(""))
+	assert.False(t, oil.IsZero("a"))
+	assert.True(t, oil.IsZero((*int)(nil)))
+	assert.False(t, oil.IsZero(&one))
+	assert.True(t, oil.IsZero(oil.Pair[int, string]{}))
+
+	assert.True(t, oil.IsZeroReflect(nil))
+	assert.True(t, oil.IsZeroReflect(0))
+	assert.False(t, oil.IsZeroReflect("a"))
+	assert.True(t, oil.IsZeroReflect((*int)(nil)))
+	assert.True(t, oil.IsZeroReflect(oil.Pair[[]int, int]{}))
+	assert.False(t, oil.IsZeroReflect(oil.Pair[[]int, int]{First: []int{}}))
+
+	assert.Equal(t, []int{1, -2}, oil.NonZero([]int{0, 1, 0, -2, 0}))
+	assert.Equal(t, []string{}, oil.NonZero([]string{"", ""}))
+	assert.Equal(t, []*int{&one}, oil.NonZero([]*int{nil, &one, nil}))
+}
+
+func TestTopoSort(t *testing.T) {
+	sorted, err := oil.TopoSort([]string{"app", "lib", "base", "util"}, map[string][]string{
+		"app":  {"lib", "util"},
+		"lib":  {"base"},
+		"util": {"base", "extra"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"base", "lib", "extra", "util", "app"}, sorted)
+
+	sortedInts, err := oil.TopoSort([]int{1, 2, 3, 4}, map[int][]int{3: {4}})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 4, 3}, sortedInts)
+
+	sortedInts, err = oil.TopoSort([]int{}, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, sortedInts)
+
+	_, err = oil.TopoSort([]string{"a", "b", "c", "d"}, map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"d", "b"}})
+	assert.EqualError(t, err, "dependency cycle: b -> c -> b")
+	_, err = oil.TopoSort([]int{1}, map[int][]int{1: {1}})
+	assert.EqualError(t, err, "dependency cycle: 1 -> 1")
+}
+
+func TestWithOptions(t *testing.T) {
+	type config struct {
+		port int
+		host string
+	}
+	base := config{port: 80, host: "localhost"}
+	c := oil.WithOptions(base,
+		func(c *config) { c.port = 8080 },
+		func(c *config) { c.host = "example.com" },
+		func(c *config) { c.port++ })
+	assert.Equal(t, config{port: 8081, host: "example.com"}, c)
+	assert.Equal(t, config{port: 80, host: "localhost"}, base)
+	assert.Equal(t, base, oil.WithOptions(base))
+}
+
+func TestTimedMemoize(t *testing.T) {
+	now := time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC)
+	calls := 0
+	square := oil.TimedMemoize(func() time.Time { return now }, time.Minute, func(x int) int { calls++; return x * x })
+	assert.Equal(t, 4, square(2))
+	assert.Equal(t, 9, square(3))
+	now = now.Add(time.Minute - 1)
+	assert.Equal(t, 4, square(2))
+	assert.Equal(t, 9, square(3))
+	assert.Equal(t, 2, calls)
+	now = now.Add(1)
+	assert.Equal(t, 4, square(2))
+	assert.Equal(t, 3, calls)
+	now = now.Add(time.Second)
+	assert.Equal(t, 4, square(2))
+	assert.Equal(t, 9, square(3))
+	assert.Equal(t, 4, calls)
+	assert.Equal(t, 16, oil.TimedMemoize(nil, time.Hour, func(x int) int { return x * x })(4))
+}
+
+func TestCoalesceChan(t *testing.T) {
+	timers := make(chan chan time.Time, 10)
+	after := func(d time.Duration) <-chan time.Time {
+		assert.Equal(t, time.Second, d)
+		c := make(chan time.Time, 1)
+		timers <- c
+		return c
+	}
+	in := make(chan int)
+	out := oil.CoalesceChan(in, time.Second, after)
+	for i := 1; i <= 3; i++ {
+		in <- i
+	}
+	stale1, stale2, last := <-timers, <-timers, <-timers
+	stale1 <- time.Time{}
+	stale2 <- time.Time{}
+	select {
+	case v := <-out:
+		t.Errorf("unexpected value %d before the quiet period", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+	last <- time.Time{}
+	assert.Equal(t, 3, <-out)
+	in <- 4
+	<-timers
+	close(in)
+	assert.Equal(t, 4, <-out)
+	_, ok := <-out
+	assert.False(t, ok)
+	in = make(chan int, 1)
+	in <- 5
+	close(in)
+	assert.Equal(t, 5, <-oil.CoalesceChan(in, time.Hour, nil))
+}
+
+func TestRange(t *testing.T) {
+	assert.Equal(t, []int{0, 3, 6, 9}, oil.Range(0, 10, 3))
+	assert.Equal(t, []int{0, 1, 2}, oil.Range(0, 3, 1))
+	assert.Equal(t, []int{3, 2, 1}, oil.Range(3, 0, -1))
+	assert.Equal(t, []int{-1, -6}, oil.Range(-1, -10, -5))
+	assert.Equal(t, []int{}, oil.Range(3, 3, 1))
+	assert.Equal(t, []int{}, oil.Range(3, 0, 1))
+	assert.Equal(t, []int{}, oil.Range(0, 3, -1))
+	assert.Equal(t, []uint8{250, 254}, oil.Range[uint8](250, 255, 4))
+	assert.Equal(t, []int8{-100, 0, 100}, oil.Range[int8](-100, 127, 100))
+	assert.Panics(t, func() { oil.Range(0, 3, 0) })
+	sum := 0
+	oil.RangeFunc(10, 0, -2, func(i int) { sum += i })
+	assert.Equal(t, 10+8+6+4+2, sum)
+}
+
+func TestSampler(t *testing.T) {
+	s := oil.NewEveryNSampler(3)
+	var sampled, calls atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 300; j++ {
+				if s.ShouldSample() {
+					sampled.Add(1)
+				}
+				calls.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(3000), calls.Load())
+	assert.Equal(t, int64(1000), sampled.Load())
+	s = oil.NewEveryNSampler(2)
+	assert.Equal(t, []bool{false, true, false, true}, []bool{s.ShouldSample(), s.ShouldSample(), s.ShouldSample(), s.ShouldSample()})
+	assert.False(t, oil.NewEveryNSampler(0).ShouldSample())
+	assert.True(t, oil.NewEveryNSampler(1).ShouldSample())
+
+	decisions := func(s *oil.Sampler) []bool {
+		d := make([]bool, 1000)
+		for i := range d {
+			d[i] = s.ShouldSample()
+		}
+		return d
+	}
+	d := decisions(oil.NewRandomSampler(0.25, 42))
+	assert.Equal(t, d, decisions(oil.NewRandomSampler(0.25, 42)))
+	n := 0
+	for _, b := range d {
+		if b {
+			n++
+		}
+	}
+	assert.InDelta(t, 250, n, 60)
+	assert.NotContains(t, decisions(oil.NewRandomSampler(0, 1)), true)
+	assert.NotContains(t, decisions(oil.NewRandomSampler(1, 1)), false)
+}
+
+func TestResult(t *testing.T) {
+	r := oil.Ok(3)
+	assert.Equal(t, oil.Result[int]{Value: 3}, r)
+	v, err := r.Unwrap()
+	assert.Equal(t, 3, v)
+	assert.NoError(t, err)
+	e := errors.New("foo")
+	r = oil.Err[int](e)
+	assert.Equal(t, oil.Result[int]{Err: e}, r)
+	v, err = r.Unwrap()
+	assert.Equal(t, 0, v)
+	assert.Equal(t, e, err)
+	c := make(chan oil.Result[string], 2)
+	c <- oil.Ok("bar")
+	c <- oil.Err[string](e)
+	assert.Equal(t, "bar", (<-c).Value)
+	assert.Equal(t, e, (<-c).Err)
+}
+
+func TestMergeMaps(t *testing.T) {
+	dst := map[string]int{"foo": 1, "bar": 2}
+	src := map[string]int{"bar": 3, "baz": 4}
+	sum := func(a, b int) int { return a + b }
+	assert.Equal(t, map[string]int{"foo": 1, "bar": 5, "baz": 4}, oil.MergeMaps(dst, src, sum))
+	assert.Equal(t, map[string]int{"foo": 1, "bar": 5, "baz": 4}, dst)
+	assert.Equal(t, map[string]int{"bar": 3, "baz": 4}, src)
+	assert.Equal(t, map[string]int{"foo": 1, "bar": 3, "baz": 4}, oil.MergeMapsOverwrite(dst, src))
+	assert.Equal(t, src, oil.MergeMaps(nil, src, sum))
+	assert.Equal(t, map[string]int{}, oil.MergeMapsOverwrite[string, int](nil, nil))
+}
+
+func TestDifferenceIntersectionUnion(t *testing.T) {
+	a, b := []int{5, 1, 2, 1, 3, 4, 2}, []int{4, 6, 1, 6}
+	assert.Equal(t, []int{5, 2, 3}, oil.Difference(a, b))
+	assert.Equal(t, []int{6}, oil.Difference(b, a))
+	assert.Equal(t, []int{1, 4}, oil.Intersection(a, b))
+	assert.Equal(t, []int{4, 1}, oil.Intersection(b, a))
+	assert.Equal(t, []int{5, 1, 2, 3, 4, 6}, oil.Union(a, b))
+	assert.Equal(t, []int{4, 6, 1, 5, 2, 3}, oil.Union(b, a))
+	assert.Equal(t, []int{5, 1, 2, 1, 3, 4, 2}, a)
+	assert.Equal(t, []int{4, 6, 1, 6}, b)
+
+	disjoint := []int{7, 8}
+	assert.Equal(t, []int{7, 8}, oil.Difference(disjoint, a))
+	assert.Equal(t, []int{}, oil.Intersection(disjoint, a))
+	assert.Equal(t, []int{7, 8, 5, 1, 2, 3, 4}, oil.Union(disjoint, a))
+
+	assert.Equal(t, []string{}, oil.Difference[string](nil, []string{"x"}))
+	assert.Equal(t, []string{"x"}, oil.Difference([]string{"x", "x"}, nil))
+	assert.Equal(t, []string{}, oil.Intersection([]string{"x"}, nil))
+	assert.Equal(t, []string{}, oil.Union[string](nil, nil))
+	assert.Equal(t, []string{"x"}, oil.Union(nil, []string{"x"}))
+}
+
+func TestEMA(t *testing.T) {
+	e := oil.NewEMA(0.5)
+	assert.Equal(t, 0.0, e.Value())
+	assert.Equal(t, 10.0, e.Add(10))
+	assert.Equal(t, 15.0, e.Add(20))
+	assert.Equal(t, 17.5, e.Add(20))
+	assert.Equal(t, 17.5, e.Value())
+	for i := 0; i < 50; i++ {
+		e.Add(100)
+	}
+	assert.InDelta(t, 100, e.Value(), 1e-9)
+	e = oil.NewEMA(0.1)
+	e.Add(0)
+	prev := 0.0
+	for i := 0; i < 100; i++ {
+		v := e.Add(1)
+		assert.Greater(t, v, prev)
+		assert.Less(t, v, 1.0)
+		prev = v
+	}
+	assert.InDelta(t, 1, prev, 1e-4)
+}
+
+func TestDerefRefSlice(t *testing.T) {
+	s := []int{1, 2, 3}
+	refs := oil.RefSlice(s)
+	assert.Len(t, refs, 3)
+	*refs[1] = 20
+	assert.Equal(t, []int{1, 2, 3}, s)
+	assert.Equal(t, []int{1, 20, 3}, oil.DerefSlice(refs))
+	refs[0] = nil
+	assert.Equal(t, []int{0, 20, 3}, oil.DerefSlice(refs))
+	assert.Equal(t, []string{}, oil.DerefSlice[string](nil))
+	assert.Equal(t, []*string{}, oil.RefSlice[string](nil))
+}
+
+func TestBatchChan(t *testing.T) {
+	timers := make(chan chan time.Time, 10)
+	after := func(d time.Duration) <-chan time.Time {
+		assert.Equal(t, time.Second, d)
+		c := make(chan time.Time, 1)
+		timers <- c
+		return c
+	}
+	in := make(chan int)
+	out := oil.BatchChan(in, 3, time.Second, after)
+	for i := 1; i <= 3; i++ {
+		in <- i
+	}
+	assert.Equal(t, []int{1, 2, 3}, <-out) // flushed without any timer firing
+	<-timers
+	<-timers
+	in <- 4
+	in <- 5
+	stale, last := <-timers, <-timers
+	stale <- time.Time{}
+	select {
+	case b := <-out:
+		t.Errorf("unexpected batch %v before maxWait", b)
+	case <-time.After(20 * time.Millisecond):
+	}
+	last <- time.Time{}
+	assert.Equal(t, []int{4, 5}, <-out)
+	in <- 6
+	<-timers
+	close(in)
+	assert.Equal(t, []int{6}, <-out)
+	_, ok := <-out
+	assert.False(t, ok)
+
+	in = make(chan int, 2)
+	in <- 7
+	in <- 8
+	close(in)
+	out = oil.BatchChan(in, 0, time.Hour, nil)
+	assert.Equal(t, []int{7}, <-out)
+	assert.Equal(t, []int{8}, <-out)
+}
+
+func TestSafeDiv(t *testing.T) {
+	assert.Equal(t, 3, oil.SafeDiv(7, 2, -1))
+	assert.Equal(t, -1, oil.SafeDiv(7, 0, -1))
+	assert.Equal(t, uint8(0), oil.SafeDiv[uint8](7, 0, 0))
+	assert.Equal(t, 3.5, oil.SafeDiv(7.0, 2, 0))
+	assert.Equal(t, 0.0, oil.SafeDiv(7.0, 0, 0))
+	assert.Equal(t, 1.0, oil.SafeDiv(0.0, 0, 1))
+	negZero := math.Copysign(0, -1)
+	assert.Equal(t, 2.0, oil.SafeDiv(1.0, negZero, 2))
+}
+
+func TestKeyedDebouncer(t *testing.T) {
+	now := time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC)
+	kd := oil.NewKeyedDebouncer[string](time.Minute, func() time.Time { return now })
+	assert.True(t, kd.Allow("foo"))
+	assert.False(t, kd.Allow("foo"))
+	assert.True(t, kd.Allow("bar"))
+	now = now.Add(30 * time.Second)
+	assert.False(t, kd.Allow("foo"))
+	assert.True(t, kd.Allow("baz"))
+	now = now.Add(30 * time.Second)
+	assert.True(t, kd.Allow("foo"))
+	assert.True(t, kd.Allow("bar"))
+	assert.False(t, kd.Allow("baz"))
+	assert.False(t, kd.Allow("foo"))
+	now = now.Add(time.Hour)
+	for _, k := range []string{"foo", "bar", "baz"} {
+		assert.True(t, kd.Allow(k))
+		assert.False(t, kd.Allow(k))
+	}
+
+	kd = oil.NewKeyedDebouncer[string](time.Hour, nil)
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if kd.Allow("foo") {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), allowed.Load())
+}
+
+func TestInsertSorted(t *testing.T) {
+	var s []int
+	for _, v := range []int{5, 1, 3, 3, 9, 0, 5, 7} {
+		s = oil.InsertSorted(s, v)
+	}
+	assert.Equal(t, []int{0, 1, 3, 3, 5, 5, 7, 9}, s)
+	s = make([]int, 0, 4)
+	s = oil.InsertSorted(s, 2)
+	s2 := oil.InsertSorted(s, 1)
+	assert.Equal(t, []int{1, 2}, s2)
+	assert.Equal(t, &s[:2][0], &s2[0]) // the capacity was reused
+
+	type item struct {
+		key   int
+		label string
+	}
+	byKey := func(a, b item) bool { return a.key < b.key }
+	var items []item
+	for _, it := range []item{{2, "a"}, {1, "b"}, {2, "c"}, {0, "d"}, {2, "e"}} {
+		items = oil.InsertSortedFunc(items, it, byKey)
+	}
+	assert.Equal(t, []item{{0, "d"}, {1, "b"}, {2, "a"}, {2, "c"}, {2, "e"}}, items)
+}
+
+func TestSplitBytes(t *testing.T) {
+	for s, want := range map[string][]string{
+		"":          {},
+		",":         {""},
+		"a":         {"a"},
+		"a,":        {"a"},
+		",a":        {"", "a"},
+		"a,,b":      {"a", "", "b"},
+		"a,b,,":     {"a", "b", ""},
+		",,foo,bar": {"", "", "foo", "bar"},
+	} {
+		got := []string{}
+		for _, r := range oil.SplitBytes([]byte(s), ',') {
+			got = append(got, string(r))
+		}
+		assert.Equal(t, want, got, s)
+	}
+	data := []byte("a,b")
+	records := oil.SplitBytes(data, ',')
+	records[0] = append(records[0], 'x') // mustn't overwrite the separator
+	assert.Equal(t, "a,b", string(data))
+}
+
+func TestScanDelimited(t *testing.T) {
+	scan := func(s string, maxRecord int) ([]string, error) {
+		records := []string{}
+		err := oil.ScanDelimited(strings.NewReader(s), '\n', maxRecord, func(r []byte) error {
+			records = append(records, string(r))
+			return nil
+		})
+		return records, err
+	}
+	for _, s := range []string{"", "\n", "a", "a\n", "\na", "a\n\nb", "a\nb\n\n", "\n\nfoo\nbar"} {
+		records, err := scan(s, 10)
+		assert.NoError(t, err, s)
+		want := []string{}
+		for _, r := range oil.SplitBytes([]byte(s), '\n') {
+			want = append(want, string(r))
+		}
+		assert.Equal(t, want, records, s)
+	}
+	records, err := scan("12345\n123456\n", 5)
+	assert.ErrorIs(t, err, bufio.ErrTooLong)
+	assert.Equal(t, []string{"12345"}, records)
+	records, err = scan(strings.Repeat("x", 10000)+"\ny", 10000)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{strings.Repeat("x", 10000), "y"}, records)
+	records, err = scan("a\n", -1)
+	assert.ErrorContains(t, err, "negative")
+	assert.Empty(t, records)
+	records, err = scan("a\nb", math.MaxInt)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, records)
+	records, err = scan("\na", 0)
+	assert.ErrorIs(t, err, bufio.ErrTooLong)
+	assert.Equal(t, []string{""}, records)
+
+	stop := errors.New("stop")
+	n := 0
+	assert.Equal(t, stop, oil.ScanDelimited(strings.NewReader("a\nb\nc"), '\n', 10, func([]byte) error {
+		if n++; n == 2 {
+			return stop
+		}
+		return nil
+	}))
+	assert.Equal(t, 2, n)
+}
+
+func TestWeightedChoice(t *testing.T) {
+	items, weights := []string{"a", "b", "c", "d"}, []float64{1, 0, 3, 6}
+	rnd := rand.New(rand.NewSource(42))
+	counts := map[string]int{}
+	for i := 0; i < 100000; i++ {
+		item, err := oil.WeightedChoice(items, weights, rnd)
+		assert.NoError(t, err)
+		counts[item]++
+	}
+	assert.Zero(t, counts["b"])
+	assert.InDelta(t, 10000, counts["a"], 500)
+	assert.InDelta(t, 30000, counts["c"], 800)
+	assert.InDelta(t, 60000, counts["d"], 800)
+
+	item, err := oil.WeightedChoice(items, []float64{0, 0, 2, 0}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "c", item)
+	for _, w := range [][]float64{{1, 2, 3}, {1, -1, 1, 1}, {1, math.NaN(), 1, 1}, {0, 0, 0, 0}, {1, math.Inf(1), 1, 1}} {
+		_, err := oil.WeightedChoice(items, w, rnd)
+		assert.Error(t, err, w)
+	}
+	_, err = oil.WeightedChoice([]string{}, []float64{}, nil)
+	assert.Error(t, err)
+}
+
+func TestWeightedChoiceDefaultRand(t *testing.T) {
+	items, weights := []string{"a", "b", "c"}, []float64{1, 2, 3}
+	draws := func(rnd *rand.Rand, n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			item, err := oil.WeightedChoice(items, weights, rnd)
+			assert.NoError(t, err)
+			b.WriteString(item)
+		}
+		return b.String()
+	}
+	// the default generator is seeded with 1, and other calls with a nil
+	// generator may have drawn from it before, so its draws must be somewhere
+	// in the sequence of a fresh generator seeded with 1
+	got := draws(nil, 50)
+	for _, item := range items {
+		assert.Contains(t, got, item)
+	}
+	assert.Contains(t, draws(rand.New(rand.NewSource(1)), 1000), got)
+}
+
+func TestMustNotNil(t *testing.T) {
+	var p *int
+	assert.PanicsWithValue(t, "foo is a nil *int", func() { oil.MustNotNil(p, "foo") })
+	var err error
+	assert.PanicsWithValue(t, "bar is nil", func() { oil.MustNotNil(err, "bar") })
+	assert.Panics(t, func() { oil.MustNotNil(map[string]int(nil), "m") })
+	assert.Panics(t, func() { oil.MustNotNil([]int(nil), "s") })
+	assert.Panics(t, func() { oil.MustNotNil((chan int)(nil), "c") })
+	assert.Panics(t, func() { oil.MustNotNil((func())(nil), "f") })
+	i := 42
+	assert.Same(t, &i, oil.MustNotNil(&i, "i"))
+	assert.Equal(t, []int{}, oil.MustNotNil([]int{}, "s"))
+	assert.Equal(t, 0, oil.MustNotNil(0, "zero"))
+	assert.Equal(t, "", oil.MustNotNil("", "empty"))
+}
+
+func TestStructToMapAndMapToStruct(t *testing.T) {
+	type record struct {
+		Name     string
+		Age      int `oil:"age"`
+		Tags     []string
+		Score    float64 `oil:"score"`
+		Skipped  string  `oil:"-"`
+		internal int
+	}
+	r := record{Name: "foo", Age: 42, Tags: []string{"a", "b"}, Score: 1.5, Skipped: "x", internal: 7}
+	m := oil.StructToMap(r)
+	assert.Equal(t, map[string]any{"Name": "foo", "age": 42, "Tags": []string{"a", "b"}, "score": 1.5}, m)
+	assert.Equal(t, m, oil.StructToMap(&r))
+	assert.Nil(t, oil.StructToMap(42))
+	assert.Nil(t, oil.StructToMap(nil))
+
+	var r2 record
+	assert.NoError(t, oil.MapToStruct(m, &r2))
+	assert.Equal(t, record{Name: "foo", Age: 42, Tags: []string{"a", "b"}, Score: 1.5}, r2)
+
+	r2 = record{Name: "unchanged", Skipped: "y"}
+	assert.NoError(t, oil.MapToStruct(map[string]any{"age": 3.0, "score": int8(2), "Tags": nil, "Skipped": "z", "extra": true}, &r2))
+	assert.Equal(t, record{Name: "unchanged", Age: 3, Score: 2, Skipped: "y"}, r2)
+
+	assert.Error(t, oil.MapToStruct(map[string]any{"age": "old"}, &r2))
+	assert.Error(t, oil.MapToStruct(map[string]any{"Name": 1}, &r2))
+	assert.Error(t, oil.MapToStruct(m, r2))
+	assert.Error(t, oil.MapToStruct(m, (*record)(nil)))
+	i := 0
+	assert.Error(t, oil.MapToStruct(m, &i))
+}
+
+func TestMapToStructNumbers(t *testing.T) {
+	type numbers struct {
+		I   int
+		I8  int8
+		U   uint
+		U8  uint8
+		F32 float32
+		F64 float64
+	}
+	for _, tc := range []struct {
+		key   string
+		value any
+		ok    bool
+		want  numbers
+	}{
+		{"I8", 127, true, numbers{I8: 127}},
+		{"I8", -128.0, true, numbers{I8: -128}},
+		{"I8", 300, false, numbers{}},
+		{"I8", uint64(200), false, numbers{}},
+		{"I", 3.0, true, numbers{I: 3}},
+		{"I", 3.9, false, numbers{}},
+		{"I", math.NaN(), false, numbers{}},
+		{"I", math.Inf(1), false, numbers{}},
+		{"I", 1e30, false, numbers{}},
+		{"I", uint64(math.MaxUint64), false, numbers{}},
+		{"U", -1.0, false, numbers{}},
+		{"U", -1, false, numbers{}},
+		{"U", 0.5, false, numbers{}},
+		{"U", 42.0, true, numbers{U: 42}},
+		{"U", int8(7), true, numbers{U: 7}},
+		{"U8", 255, true, numbers{U8: 255}},
+		{"U8", 256, false, numbers{}},
+		{"U8", uint16(256), false, numbers{}},
+		{"U8", 256.0, false, numbers{}},
+		{"F32", 1.5, true, numbers{F32: 1.5}},
+		{"F32", 1e300, false, numbers{}},
+		{"F32", 12, true, numbers{F32: 12}},
+		{"F32", 0.1, false, numbers{}},
+		{"F32", 0.5, true, numbers{F32: 0.5}},
+		{"F32", 1 << 24, true, numbers{F32: 1 << 24}},
+		{"F32", 1<<24 + 1, false, numbers{}},
+		{"F64", float32(0.1), true, numbers{F64: float64(float32(0.1))}},
+		{"F64", int64(1 << 53), true, numbers{F64: 1 << 53}},
+		{"F64", int64(1<<53 + 1), false, numbers{}},
+		{"F64", uint64(math.MaxUint64), false, numbers{}},
+		{"F64", int64(math.MaxInt64), false, numbers{}},
+		{"F64", int64(math.MinInt64), true, numbers{F64: math.MinInt64}},
+	} {
+		var n numbers
+		err := oil.MapToStruct(map[string]any{tc.key: tc.value}, &n)
+		if tc.ok {
+			assert.NoError(t, err, "%s = %v", tc.key, tc.value)
+		} else {
+			assert.Error(t, err, "%s = %v", tc.key, tc.value)
+		}
+		assert.Equal(t, tc.want, n, "%s = %v", tc.key, tc.value)
+	}
+}
@@ -14,6 +14,7 @@ package singleton
 
 import (
 	"sync"
+	"time"
 )
 
 // Singleton is a singleton that can be used concurrently.
@@ -46,6 +47,88 @@ func (s *Singleton[T]) GetOrCreate(create func() T) T {
 	return result
 }
 
+// GetOrCreateOrFail is the same as GetOrCreate but allows the creation to fail.
+// If the creation function returns an error, nothing is stored, and a later
+// call will call a creation function again.
+func (s *Singleton[T]) GetOrCreateOrFail(create func() (T, error)) (T, error) {
+	s.mu.RLock()
+	if s.created {
+		defer s.mu.RUnlock()
+		return s.instance, nil
+	}
+	s.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.created { // we need to test again, it might have been set in the mean time
+		return s.instance, nil
+	}
+	result, err := create()
+	if err != nil {
+		return result, err
+	}
+	s.instance, s.created = result, true
+	return result, nil
+}
+
+// IsInitialized tells if the singleton was created.  It never creates it.
+func (s *Singleton[T]) IsInitialized() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.created
+}
+
+// GetIfSet returns the singleton and true if it was already created, or a zero
+// value and false otherwise.  It never creates the singleton.
+func (s *Singleton[T]) GetIfSet() (T, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.instance, s.created
+}
+
+// TTLSingleton is a singleton that gets created again when it gets older than a
+// TTL, e.g. to cache a token that expires.
+// It mustn't be copied after being used.
+type TTLSingleton[T any] struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu       sync.RWMutex // PROTECTS EVERYTHING BELOW
+	created  time.Time    // zero if the singleton wasn't created yet
+	instance T
+}
+
+// NewTTLSingleton creates a TTLSingleton whose age is measured with now, or
+// time.Now if it's nil.
+func NewTTLSingleton[T any](ttl time.Duration, now func() time.Time) *TTLSingleton[T] {
+	if now == nil {
+		now = time.Now
+	}
+	return &TTLSingleton[T]{ttl: ttl, now: now}
+}
+
+// GetOrCreate returns the singleton, calling the creation function to create
+// it if it doesn't exist yet or if the TTL has elapsed since its creation.
+func (s *TTLSingleton[T]) GetOrCreate(create func() T) T {
+	s.mu.RLock()
+	if s.isFresh() {
+		defer s.mu.RUnlock()
+		return s.instance
+	}
+	s.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.isFresh() { // we need to test again, it might have been set in the mean time
+		return s.instance
+	}
+	result := create()
+	s.instance, s.created = result, s.now()
+	return result
+}
+
+func (s *TTLSingleton[T]) isFresh() bool {
+	return !s.created.IsZero() && s.now().Sub(s.created) < s.ttl
+}
+
 // SingletonMap is a map of singletons that can be used concurrently.
 // It mustn't be copied after being used.
 type SingletonMap[K comparable, V any] struct {
@@ -102,3 +185,133 @@ func (sm *SingletonMap[K, V]) GetOrCreateOrFail(key K, create func(key K) (V, er
 	}
 	return result, nil
 }
+
+// Keys returns the keys of all the singletons created so far, in no particular order.
+func (sm *SingletonMap[K, V]) Keys() []K {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	keys := make([]K, 0, len(sm.instances))
+	for k := range sm.instances {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Delete deletes the singleton for a key, so the next call to GetOrCreate for
+// that key will create it again.
+// Cleaning up the deleted value, if needed, is the responsibility of the caller.
+func (sm *SingletonMap[K, V]) Delete(key K) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	delete(sm.instances, key)
+}
+
+// DeleteAll deletes all the singletons of the SingletonMap, so the next calls
+// to GetOrCreate will create them again.
+func (sm *SingletonMap[K, V]) DeleteAll() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.instances = nil
+}
+
+// Len returns the number of singletons in the SingletonMap.
+func (sm *SingletonMap[K, V]) Len() int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return len(sm.instances)
+}
+
+// Snapshot returns a shallow copy of the map of singletons.
+func (sm *SingletonMap[K, V]) Snapshot() map[K]V {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	m := make(map[K]V, len(sm.instances))
+	for k, v := range sm.instances {
+		m[k] = v
+	}
+	return m
+}
+
+// GetIfExists returns the singleton for a key and true if it was already
+// created, or a zero value and false otherwise.  It never creates the singleton.
+func (sm *SingletonMap[K, V]) GetIfExists(key K) (V, bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	v, ok := sm.instances[key]
+	return v, ok
+}
+
+// Range calls a function for each singleton of the SingletonMap, in no
+// particular order, and stops if the function returns false, like sync.Map.Range.
+// The SingletonMap is locked during the whole iteration, so the function mustn't
+// call any method of the SingletonMap, or it could deadlock.
+func (sm *SingletonMap[K, V]) Range(fn func(key K, value V) bool) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	for k, v := range sm.instances {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+// DeleteIf deletes all the singletons for which a predicate returns true, and
+// returns the number of deleted singletons.
+// The SingletonMap is locked during the whole operation, so the predicate
+// mustn't call any method of the SingletonMap, or it could deadlock.
+func (sm *SingletonMap[K, V]) DeleteIf(predicate func(key K, value V) bool) int {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	n := 0
+	for k, v := range sm.instances {
+		if predicate(k, v) {
+			delete(sm.instances, k)
+			n++
+		}
+	}
+	return n
+}
+
+// registry maps names to singletons registered with Register or RegisterAll.
+var registry sync.Map
+
+// Register registers a singleton in a global registry under a name, so it can
+// later be found with Lookup, e.g. by code that can't import the package that
+// defines it.  It replaces any singleton registered with the same name.
+func Register[T any](name string, s *Singleton[T]) { registry.Store(name, s) }
+
+// RegisterAll registers several singletons at once, like calling Register for
+// each of them.  The values of the map should be *Singleton[T] with arbitrary
+// types T.
+func RegisterAll(m map[string]any) {
+	for name, s := range m {
+		registry.Store(name, s)
+	}
+}
+
+// Lookup returns a singleton registered under a name and true, or nil and
+// false if there's no singleton of type *Singleton[T] registered with that name.
+func Lookup[T any](name string) (*Singleton[T], bool) {
+	v, ok := registry.Load(name)
+	if !ok {
+		return nil, false
+	}
+	s, ok := v.(*Singleton[T])
+	return s, ok
+}
+
+// OneTime runs a function only once, like sync.Once, but it's a named type
+// that can be reset in tests.
+// It mustn't be copied after being used.
+type OneTime struct {
+	once sync.Once
+}
+
+// Run calls a function, unless Run was already called before (or since the
+// last UnsafeReset), in which case it does nothing.
+// Concurrent callers block until the unique call to a function returns.
+func (o *OneTime) Run(f func()) { o.once.Do(f) }
+
+// UnsafeReset resets the OneTime, so the next call to Run will call its function.
+// It's meant for tests: it mustn't be called concurrently with Run.
+func (o *OneTime) UnsafeReset() { o.once = sync.Once{} }
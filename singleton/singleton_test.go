@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -23,6 +24,18 @@ func (c *createlog) create() int {
 	return -1
 }
 
+// createAndSucceed is meant to be passed in argument to Singleton.GetOrCreateOrFail
+func (c *createlog) createAndSucceed() (int, error) {
+	c.created <- -1
+	return -1, nil
+}
+
+// createAndFail is meant to be passed in argument to Singleton.GetOrCreateOrFail
+func (c *createlog) createAndFail() (int, error) {
+	c.created <- -2
+	return 0, fmt.Errorf("injected error")
+}
+
 // createWithKey is meant to be passed in argument to SingletonMap.GetOrCreate
 func (c *createlog) createWithKey(key int) string {
 	c.created <- key
@@ -73,6 +86,51 @@ func TestSingletonBasics(t *testing.T) {
 	assert.Equal(t, createlog.all(), []int{-1})
 }
 
+func TestSingletonGetOrCreateOrFail(t *testing.T) {
+	t.Parallel()
+	var s singleton.Singleton[int]
+	createlog := newCreatelog(100)
+	_, err := s.GetOrCreateOrFail(createlog.createAndFail)
+	assert.Error(t, err)
+	_, err = s.GetOrCreateOrFail(createlog.createAndFail)
+	assert.Error(t, err)
+	n, err := s.GetOrCreateOrFail(createlog.createAndSucceed)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, n)
+	n, err = s.GetOrCreateOrFail(createlog.createAndFail)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, n)
+	assert.Equal(t, -1, s.GetOrCreate(createlog.create))
+	assert.Equal(t, []int{-2, -2, -1}, createlog.all())
+}
+
+func TestSingletonIsInitialized(t *testing.T) {
+	t.Parallel()
+	var s singleton.Singleton[int]
+	createlog := newCreatelog(100)
+	assert.False(t, s.IsInitialized())
+	_, err := s.GetOrCreateOrFail(createlog.createAndFail)
+	assert.Error(t, err)
+	assert.False(t, s.IsInitialized())
+	s.GetOrCreate(createlog.create)
+	assert.True(t, s.IsInitialized())
+	assert.Equal(t, []int{-2, -1}, createlog.all())
+}
+
+func TestSingletonGetIfSet(t *testing.T) {
+	t.Parallel()
+	var s singleton.Singleton[int]
+	createlog := newCreatelog(100)
+	n, ok := s.GetIfSet()
+	assert.False(t, ok)
+	assert.Equal(t, 0, n)
+	s.GetOrCreate(createlog.create)
+	n, ok = s.GetIfSet()
+	assert.True(t, ok)
+	assert.Equal(t, -1, n)
+	assert.Equal(t, []int{-1}, createlog.all())
+}
+
 func TestSingletonRaces(t *testing.T) {
 	t.Parallel()
 	var s singleton.Singleton[int]
@@ -96,6 +154,51 @@ func TestSingletonRaces(t *testing.T) {
 	assert.Equal(t, []int{-1}, createlog.all())
 }
 
+func TestTTLSingleton(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC)
+	s := singleton.NewTTLSingleton[int](time.Hour, func() time.Time { return now })
+	createlog := newCreatelog(100)
+	assert.Equal(t, -1, s.GetOrCreate(createlog.create))
+	now = now.Add(time.Hour - 1)
+	assert.Equal(t, -1, s.GetOrCreate(createlog.create))
+	assert.Equal(t, []int{-1}, createlog.all())
+	now = now.Add(1)
+	assert.Equal(t, -1, s.GetOrCreate(createlog.create))
+	assert.Equal(t, -1, s.GetOrCreate(createlog.create))
+	assert.Equal(t, []int{-1}, createlog.all())
+	now = now.Add(2 * time.Hour)
+	assert.Equal(t, -1, s.GetOrCreate(createlog.create))
+	assert.Equal(t, []int{-1}, createlog.all())
+}
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+	var s1, s2 singleton.Singleton[int]
+	var s3 singleton.Singleton[string]
+	singleton.Register("TestRegistry1", &s1)
+	singleton.RegisterAll(map[string]any{"TestRegistry2": &s2, "TestRegistry3": &s3})
+	s, ok := singleton.Lookup[int]("TestRegistry1")
+	assert.True(t, ok)
+	assert.Same(t, &s1, s)
+	s, ok = singleton.Lookup[int]("TestRegistry2")
+	assert.True(t, ok)
+	assert.Same(t, &s2, s)
+	ss, ok := singleton.Lookup[string]("TestRegistry3")
+	assert.True(t, ok)
+	assert.Same(t, &s3, ss)
+	s, ok = singleton.Lookup[int]("TestRegistry3")
+	assert.False(t, ok)
+	assert.Nil(t, s)
+	s, ok = singleton.Lookup[int]("TestRegistryNoexist")
+	assert.False(t, ok)
+	assert.Nil(t, s)
+	singleton.Register("TestRegistry1", &s2)
+	s, ok = singleton.Lookup[int]("TestRegistry1")
+	assert.True(t, ok)
+	assert.Same(t, &s2, s)
+}
+
 func TestSingletonMapBasics(t *testing.T) {
 	t.Parallel()
 	var sm singleton.SingletonMap[int, string]
@@ -112,6 +215,98 @@ func TestSingletonMapBasics(t *testing.T) {
 	assert.Equal(t, createlog.all(), []int{1, 2, 3, -4, 4})
 }
 
+func TestSingletonMapKeys(t *testing.T) {
+	t.Parallel()
+	var sm singleton.SingletonMap[int, string]
+	createlog := newCreatelog(100)
+	assert.NotNil(t, sm.Keys())
+	assert.Empty(t, sm.Keys())
+	sm.GetOrCreate(1, createlog.createWithKey)
+	sm.GetOrCreate(2, createlog.createWithKey)
+	sm.GetOrCreateOrFail(3, createlog.createWithKeyAndFail)
+	assert.ElementsMatch(t, []int{1, 2}, sm.Keys())
+}
+
+func TestSingletonMapDelete(t *testing.T) {
+	t.Parallel()
+	var sm singleton.SingletonMap[int, string]
+	createlog := newCreatelog(100)
+	sm.Delete(1)
+	assert.Equal(t, "1", sm.GetOrCreate(1, createlog.createWithKey))
+	assert.Equal(t, "2", sm.GetOrCreate(2, createlog.createWithKey))
+	sm.Delete(1)
+	assert.Equal(t, []int{2}, sm.Keys())
+	assert.Equal(t, "1", sm.GetOrCreate(1, createlog.createWithKey))
+	assert.Equal(t, "2", sm.GetOrCreate(2, createlog.createWithKey))
+	assert.Equal(t, []int{1, 2, 1}, createlog.all())
+}
+
+func TestSingletonMapIntrospection(t *testing.T) {
+	t.Parallel()
+	var sm singleton.SingletonMap[int, string]
+	createlog := newCreatelog(100)
+	assert.Equal(t, 0, sm.Len())
+	assert.Equal(t, map[int]string{}, sm.Snapshot())
+	sm.GetOrCreate(1, createlog.createWithKey)
+	sm.GetOrCreate(2, createlog.createWithKey)
+	assert.Equal(t, 2, sm.Len())
+	snapshot := sm.Snapshot()
+	assert.Equal(t, map[int]string{1: "1", 2: "2"}, snapshot)
+	snapshot[3] = "3"
+	assert.Equal(t, 2, sm.Len())
+	v, ok := sm.GetIfExists(2)
+	assert.True(t, ok)
+	assert.Equal(t, "2", v)
+	v, ok = sm.GetIfExists(3)
+	assert.False(t, ok)
+	assert.Equal(t, "", v)
+	assert.Equal(t, []int{1, 2}, createlog.all())
+}
+
+func TestSingletonMapDeleteIf(t *testing.T) {
+	t.Parallel()
+	var sm singleton.SingletonMap[int, string]
+	createlog := newCreatelog(100)
+	assert.Equal(t, 0, sm.DeleteIf(func(int, string) bool { return true }))
+	for i := 1; i <= 5; i++ {
+		sm.GetOrCreate(i, createlog.createWithKey)
+	}
+	assert.Equal(t, 2, sm.DeleteIf(func(k int, v string) bool { return k%2 == 0 && v == strconv.Itoa(k) }))
+	assert.ElementsMatch(t, []int{1, 3, 5}, sm.Keys())
+	assert.Equal(t, 0, sm.DeleteIf(func(int, string) bool { return false }))
+	assert.Equal(t, 3, sm.Len())
+}
+
+func TestSingletonMapDeleteAll(t *testing.T) {
+	t.Parallel()
+	var sm singleton.SingletonMap[int, string]
+	createlog := newCreatelog(100)
+	sm.DeleteAll()
+	sm.GetOrCreate(1, createlog.createWithKey)
+	sm.GetOrCreate(2, createlog.createWithKey)
+	sm.DeleteAll()
+	assert.Equal(t, 0, sm.Len())
+	assert.Equal(t, "1", sm.GetOrCreate(1, createlog.createWithKey))
+	assert.Equal(t, 1, sm.Len())
+	assert.Equal(t, []int{1, 2, 1}, createlog.all())
+}
+
+func TestSingletonMapRange(t *testing.T) {
+	t.Parallel()
+	var sm singleton.SingletonMap[int, string]
+	createlog := newCreatelog(100)
+	sm.Range(func(int, string) bool { t.Fatal("Range called its function on an empty map"); return true })
+	for i := 1; i <= 5; i++ {
+		sm.GetOrCreate(i, createlog.createWithKey)
+	}
+	m := make(map[int]string)
+	sm.Range(func(k int, v string) bool { m[k] = v; return true })
+	assert.Equal(t, map[int]string{1: "1", 2: "2", 3: "3", 4: "4", 5: "5"}, m)
+	n := 0
+	sm.Range(func(int, string) bool { n++; return n < 2 })
+	assert.Equal(t, 2, n)
+}
+
 func TestSingletonMapRaces(t *testing.T) {
 	t.Parallel()
 	var sm singleton.SingletonMap[int, string]
@@ -131,7 +326,7 @@ func TestSingletonMapRaces(t *testing.T) {
 			assert.Error(t, err)
 		}
 		for j := 1; j <= Q; j++ {
-			go func(i int, s string) {
+			go func(i, j int, s string) {
 				<-leash
 				switch (i + j) % 2 {
 				case 0:
@@ -140,7 +335,7 @@ func TestSingletonMapRaces(t *testing.T) {
 					assert.Equal(t, newPair(s, error(nil)), newPair(sm.GetOrCreateOrFail(i, createlog.createWithKeyAndSucceed)))
 				}
 				wg.Done()
-			}(i, s)
+			}(i, j, s)
 		}
 	}
 	close(leash)
@@ -150,3 +345,25 @@ func TestSingletonMapRaces(t *testing.T) {
 	}
 	assert.ElementsMatch(t, expected, createlog.all())
 }
+
+func TestOneTime(t *testing.T) {
+	t.Parallel()
+	var o singleton.OneTime
+	createlog := newCreatelog(1000)
+	var wg sync.WaitGroup
+	wg.Add(100)
+	for i := 0; i < 100; i++ {
+		go func() { o.Run(func() { createlog.create() }); wg.Done() }()
+	}
+	wg.Wait()
+	assert.Equal(t, []int{-1}, createlog.all())
+	o.Run(func() { createlog.create() })
+	assert.Equal(t, []int{}, createlog.all())
+	o.UnsafeReset()
+	o.Run(func() { createlog.create() })
+	o.Run(func() { createlog.create() })
+	assert.Equal(t, []int{-1}, createlog.all())
+	m := map[string]*singleton.OneTime{"foo": {}}
+	m["foo"].Run(func() { createlog.create() })
+	assert.Equal(t, []int{-1}, createlog.all())
+}
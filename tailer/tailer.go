@@ -65,6 +65,21 @@ func (t *LineTailer) ReadLine() ([]byte, error) {
 	}
 }
 
+// ReadRemaining returns a copy of the buffered bytes that ReadLine didn't
+// return yet, and forgets them, or nil and io.EOF if there aren't any.
+// It's meant to get the last line of the input when it doesn't end with a
+// '\n', after ReadLine returned io.EOF; when the input isn't over, it returns
+// an incomplete line, whose rest ReadLine will return as a line.
+// It doesn't read from the io.Reader.
+func (t *LineTailer) ReadRemaining() ([]byte, error) {
+	if t.lineStart >= t.readOffset {
+		return nil, io.EOF
+	}
+	remaining := append([]byte{}, t.buffer[t.lineStart:t.readOffset]...)
+	t.lineStart, t.readOffset, t.scanOffset = 0, 0, 0
+	return remaining, nil
+}
+
 func (t *LineTailer) scan() []byte {
 	k := bytes.IndexByte(t.buffer[t.scanOffset:t.readOffset], '\n')
 	if k < 0 {
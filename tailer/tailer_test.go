@@ -258,3 +258,47 @@ func TestReadlineWithPartialReadsInterruptedByTransientErrors(t *testing.T) {
 		}
 	}
 }
+
+func TestReadRemaining(t *testing.T) {
+	t.Parallel()
+	for _, initialBufSize := range []int{1, 2, 3, 5, 10, 20} {
+		var buf bytes.Buffer
+		tailer := NewLineTailer(&buf, initialBufSize)
+		remaining, err := tailer.ReadRemaining()
+		require.Equal(t, io.EOF, err)
+		require.Nil(t, remaining)
+
+		writeAll(t, &buf, []byte("foo\nbar\nbaz"))
+		for _, expected := range []string{"foo", "bar"} {
+			line, err := tailer.ReadLine()
+			require.NoError(t, err)
+			require.Equal(t, expected, string(line))
+		}
+		line, err := tailer.ReadLine()
+		require.Equal(t, io.EOF, err)
+		require.Nil(t, line)
+		remaining, err = tailer.ReadRemaining()
+		require.NoError(t, err)
+		require.Equal(t, "baz", string(remaining))
+		remaining, err = tailer.ReadRemaining()
+		require.Equal(t, io.EOF, err)
+		require.Nil(t, remaining)
+
+		// mid-stream, the rest of the line is returned by ReadLine
+		writeAll(t, &buf, []byte("qux"))
+		_, err = tailer.ReadLine()
+		require.Equal(t, io.EOF, err)
+		remaining, err = tailer.ReadRemaining()
+		require.NoError(t, err)
+		require.Equal(t, "qux", string(remaining))
+		writeAll(t, &buf, []byte("quux\nlast"))
+		line, err = tailer.ReadLine()
+		require.NoError(t, err)
+		require.Equal(t, "quux", string(line))
+		_, err = tailer.ReadLine()
+		require.Equal(t, io.EOF, err)
+		remaining, err = tailer.ReadRemaining()
+		require.NoError(t, err)
+		require.Equal(t, "last", string(remaining))
+	}
+}
@@ -2,6 +2,7 @@
 package vle
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -61,7 +62,11 @@ type BufioReader interface {
 // ReadSigned reads and parses a signed integer.
 // It returns the integer, the number of bytes Discard()ed from the reader, and an error.
 // Note the error can be non-nil even if an integer was successfully read and parsed.  The real test to know if an integer was parsed is to check that the number of bytes discarded (second returned item) is >0.
-func ReadSigned[N constraints.Signed](r BufioReader) (N, int, error) {
+func ReadSigned[N constraints.Signed](r BufioReader) (N, int, error) { return readSigned[N](r) }
+
+// readSigned is ReadSigned, but the type constraint allows calling it for
+// integers that aren't known to be signed at compile time.
+func readSigned[N constraints.Integer](r BufioReader) (N, int, error) {
 	nBits := uint(unsafe.Sizeof(N(0)) * 8)
 	maxBytes := int((nBits + 6) / 7)
 	buf, err := r.Peek(int((nBits + 6) / 7))
@@ -94,7 +99,11 @@ func ReadSigned[N constraints.Signed](r BufioReader) (N, int, error) {
 // ReadUnsigned reads and parses an unsigned integer.
 // It returns the integer, the number of bytes Discard()ed from the reader, and an error.
 // Note the error can be non-nil even if an integer was successfully read and parsed.  The real test to know if an integer was parsed is to check that the number of bytes discarded (second returned item) is >0.
-func ReadUnsigned[N constraints.Unsigned](r BufioReader) (N, int, error) {
+func ReadUnsigned[N constraints.Unsigned](r BufioReader) (N, int, error) { return readUnsigned[N](r) }
+
+// readUnsigned is ReadUnsigned, but the type constraint allows calling it for
+// integers that aren't known to be unsigned at compile time.
+func readUnsigned[N constraints.Integer](r BufioReader) (N, int, error) {
 	nBits := uint(unsafe.Sizeof(N(0)) * 8)
 	maxBytes := int((nBits + 6) / 7)
 	buf, err := r.Peek(maxBytes)
@@ -114,3 +123,201 @@ func ReadUnsigned[N constraints.Unsigned](r BufioReader) (N, int, error) {
 	r.Discard(l)
 	return n, l, err
 }
+
+// ReadInt reads and parses an integer of any integer type: it behaves like
+// ReadSigned if the type is signed, or like ReadUnsigned if it's unsigned.
+// It's convenient in generic code, when the signedness of the type isn't known
+// at compile time.
+func ReadInt[N constraints.Integer](r BufioReader) (N, int, error) {
+	if isSigned[N]() {
+		return readSigned[N](r)
+	}
+	return readUnsigned[N](r)
+}
+
+func isSigned[N constraints.Integer]() bool {
+	var zero N
+	return zero-1 < zero
+}
+
+// bytesReader implements BufioReader on top of a byte slice.
+type bytesReader struct{ b []byte }
+
+func (br *bytesReader) Discard(n int) (int, error) {
+	if n > len(br.b) {
+		n = len(br.b)
+	}
+	br.b = br.b[n:]
+	return n, nil
+}
+
+func (br *bytesReader) Peek(n int) ([]byte, error) {
+	if n > len(br.b) {
+		return br.b, io.EOF
+	}
+	return br.b[:n], nil
+}
+
+// ReadSignedBytes parses a signed integer at the start of a byte slice.
+// It returns the integer and the number of bytes it was marshaled on.
+// Unlike ReadSigned, the error is nil whenever an integer was parsed, and it
+// never panics, whatever the content of the slice.
+func ReadSignedBytes[N constraints.Signed](b []byte) (N, int, error) {
+	return fromBytes(ReadSigned[N], b)
+}
+
+// ReadUnsignedBytes parses an unsigned integer at the start of a byte slice.
+// It returns the integer and the number of bytes it was marshaled on.
+// Unlike ReadUnsigned, the error is nil whenever an integer was parsed, and it
+// never panics, whatever the content of the slice.
+func ReadUnsignedBytes[N constraints.Unsigned](b []byte) (N, int, error) {
+	return fromBytes(ReadUnsigned[N], b)
+}
+
+func fromBytes[N constraints.Integer](read func(BufioReader) (N, int, error), b []byte) (N, int, error) {
+	n, l, err := read(&bytesReader{b: b})
+	switch {
+	case l > 0:
+		return n, l, nil
+	case err == nil || errors.Is(err, io.EOF):
+		return 0, 0, fmt.Errorf("vle parse error: %T can't be parsed from %d bytes - %w", n, len(b), io.ErrUnexpectedEOF)
+	}
+	return 0, 0, err
+}
+
+// CheckRoundTrip parses the start of a byte slice as both a signed and an
+// unsigned 64 bits integer, re-marshals whatever could be parsed, and verifies
+// that the result is consistent: parsing it again gives the same integer, and
+// if the input was in canonical (shortest) form, the marshaled bytes are
+// identical to the input.
+// It returns an error if an inconsistency is found, and nil if everything's
+// fine or nothing could be parsed.  It never panics, so it can be used as a
+// fuzzing target.
+func CheckRoundTrip(b []byte) error {
+	if err := checkRoundTrip(ReadSignedBytes[int64], EncodeSigned[int64], b); err != nil {
+		return err
+	}
+	return checkRoundTrip(ReadUnsignedBytes[uint64], EncodeUnsigned[uint64], b)
+}
+
+func checkRoundTrip[N constraints.Integer](read func([]byte) (N, int, error), encode func(N) []byte, b []byte) error {
+	n, l, err := read(b)
+	if err != nil {
+		return nil
+	}
+	marshaled := encode(n)
+	if len(marshaled) > l {
+		return fmt.Errorf("vle round trip error: %T %d parsed from %d bytes %x is marshaled to longer %x", n, n, l, b[:l], marshaled)
+	}
+	if len(marshaled) == l && !bytes.Equal(marshaled, b[:l]) {
+		return fmt.Errorf("vle round trip error: %T %d parsed from %x is marshaled to %x", n, n, b[:l], marshaled)
+	}
+	if n2, l2, err := read(marshaled); err != nil || n2 != n || l2 != len(marshaled) {
+		return fmt.Errorf("vle round trip error: %T %d is marshaled to %x, which parses to %d (%d bytes, error %v)", n, n, marshaled, n2, l2, err)
+	}
+	return nil
+}
+
+// EncodeDeltas marshals a slice of integers sorted in ascending order
+// compactly: the first integer is marshaled like ReadInt expects it, then each
+// difference with the previous integer is marshaled as an unsigned integer.
+// Unsorted slices are marshaled correctly too, but not compactly.
+// An empty slice is marshaled to no bytes at all.
+func EncodeDeltas[N constraints.Integer](sorted []N) []byte {
+	if len(sorted) <= 0 {
+		return []byte{}
+	}
+	b := encodeInt(sorted[0])
+	for i := 1; i < len(sorted); i++ {
+		// the difference wraps around for unsorted slices, which ReadDeltas undoes
+		b = append(b, EncodeUnsigned(deltaMask[N]()&(uint64(sorted[i])-uint64(sorted[i-1])))...)
+	}
+	return b
+}
+
+// ReadDeltas parses a byte slice marshaled by EncodeDeltas, which must be the
+// whole slice.
+func ReadDeltas[N constraints.Integer](b []byte) ([]N, error) {
+	result := []N{}
+	if len(b) <= 0 {
+		return result, nil
+	}
+	n, l, err := fromBytes(ReadInt[N], b)
+	if err != nil {
+		return nil, err
+	}
+	result = append(result, n)
+	for b = b[l:]; len(b) > 0; b = b[l:] {
+		var delta uint64
+		if delta, l, err = ReadUnsignedBytes[uint64](b); err != nil {
+			return nil, err
+		}
+		if delta&^deltaMask[N]() != 0 {
+			return nil, fmt.Errorf("vle parse error: delta %d overflows %T", delta, n)
+		}
+		n += N(delta)
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// deltaMask returns a mask of the bits of an uint64 that fit in an N.
+func deltaMask[N constraints.Integer]() uint64 {
+	return ^uint64(0) >> (64 - unsafe.Sizeof(N(0))*8)
+}
+
+// encodeInt marshals an integer of any integer type, the way ReadInt parses it.
+// The marshaled bytes only depend on the value, not on the type, hence the
+// conversions to 64 bits integers.
+func encodeInt[N constraints.Integer](n N) []byte {
+	if isSigned[N]() {
+		return EncodeSigned(int64(n))
+	}
+	return EncodeUnsigned(uint64(n))
+}
+
+// PackFields packs small unsigned integers into a single uint64, which can
+// then be marshaled with EncodeUnsigned: each value takes the number of bits
+// given by the bitWidth with the same index, the first value taking the least
+// significant bits.
+// It returns an error if there isn't one bit width per value, if the bit
+// widths sum to more than 64, or if a value doesn't fit its bit width.
+func PackFields(values []uint64, bitWidths []uint8) (uint64, error) {
+	if len(values) != len(bitWidths) {
+		return 0, fmt.Errorf("vle pack error: %d values with %d bit widths", len(values), len(bitWidths))
+	}
+	packed, shift := uint64(0), uint(0)
+	for i, v := range values {
+		w := uint(bitWidths[i])
+		if shift+w > 64 {
+			return 0, fmt.Errorf("vle pack error: bit widths sum to more than 64 bits at value %d", i)
+		}
+		if v&^fieldMask(w) != 0 {
+			return 0, fmt.Errorf("vle pack error: value %d (%d) doesn't fit in %d bits", i, v, w)
+		}
+		packed |= v << shift
+		shift += w
+	}
+	return packed, nil
+}
+
+// UnpackFields unpacks integers packed by PackFields with the same bit widths.
+// Fields beyond the 64th bit, which PackFields doesn't allow, unpack to 0.
+func UnpackFields(packed uint64, bitWidths []uint8) []uint64 {
+	values, shift := make([]uint64, len(bitWidths)), uint(0)
+	for i, w := range bitWidths {
+		if shift < 64 {
+			values[i] = (packed >> shift) & fieldMask(uint(w))
+		}
+		shift += uint(w)
+	}
+	return values
+}
+
+// fieldMask returns a mask of the width least significant bits of an uint64.
+func fieldMask(width uint) uint64 {
+	if width >= 64 {
+		return ^uint64(0)
+	}
+	return (uint64(1) << width) - 1
+}
@@ -5,6 +5,8 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math/rand"
+	"slices"
 	"testing"
 
 	"golang.org/x/exp/constraints"
@@ -131,6 +133,16 @@ func testReadIntNoError[N constraints.Integer](
 	}
 }
 
+func TestReadInt(t *testing.T) {
+	t.Parallel()
+	testReadIntNoError[int16](t, ReadInt[int16], EncodeSigned[int16], -0x8000, 0x7fff)
+	testReadIntNoError[uint16](t, ReadInt[uint16], EncodeUnsigned[uint16], 0, 0xffff)
+	n, l, err := ReadInt[int64](bufio.NewReader(bytes.NewReader(EncodeSigned(int64(-0x8000000000000000)))))
+	require.Equal(t, int64(-0x8000000000000000), n)
+	require.Equal(t, 10, l)
+	require.NoError(t, err)
+}
+
 func TestSignedMaxLength(t *testing.T) {
 	t.Parallel()
 	testSignedMaxLength[int8, uint8](t)
@@ -286,3 +298,140 @@ func TestReadIntParseError(t *testing.T) {
 	require.LessOrEqual(t, l, 0)
 	require.Equal(t, []byte{0xff}, oil.First(r.Peek(1)))
 }
+
+func TestReadBytes(t *testing.T) {
+	t.Parallel()
+	for _, n := range []int32{0, 1, -1, 0x3f, 0x40, -0x41, 0x7fffffff, -0x80000000} {
+		b := EncodeSigned(n)
+		got, l, err := ReadSignedBytes[int32](append(b, 0xff))
+		require.NoError(t, err)
+		require.Equal(t, n, got)
+		require.Equal(t, len(b), l)
+		if len(b) > 1 {
+			_, l, err = ReadSignedBytes[int32](b[:len(b)-1])
+			require.ErrorContains(t, err, "parse")
+			require.Equal(t, 0, l)
+		}
+	}
+	for _, n := range []uint32{0, 1, 0x7f, 0x80, 0xffffffff} {
+		b := EncodeUnsigned(n)
+		got, l, err := ReadUnsignedBytes[uint32](append(b, 0xff))
+		require.NoError(t, err)
+		require.Equal(t, n, got)
+		require.Equal(t, len(b), l)
+		if len(b) > 1 {
+			_, l, err = ReadUnsignedBytes[uint32](b[:len(b)-1])
+			require.ErrorContains(t, err, "parse")
+			require.Equal(t, 0, l)
+		}
+	}
+	_, l, err := ReadUnsignedBytes[uint8](nil)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Equal(t, 0, l)
+	_, l, err = ReadUnsignedBytes[uint8]([]byte{0x81, 0x82, 0x03})
+	require.ErrorContains(t, err, "parse")
+	require.Equal(t, 0, l)
+}
+
+func TestCheckRoundTrip(t *testing.T) {
+	t.Parallel()
+	for _, b := range [][]byte{
+		nil,
+		{0},
+		{0x40},
+		{0x80, 0x01},
+		{0xc0, 0x80, 0x80, 0x01},
+		EncodeSigned(int64(-0x8000000000000000)),
+		EncodeUnsigned(uint64(0xffffffffffffffff)),
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f},
+	} {
+		require.NoErrorf(t, CheckRoundTrip(b), "%x", b)
+	}
+}
+
+func FuzzCheckRoundTrip(f *testing.F) {
+	f.Add([]byte{0x81, 0x00})
+	f.Add([]byte{0xbf, 0xff, 0x7f})
+	f.Add(EncodeSigned(int64(-0x8000000000000000)))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		require.NoError(t, CheckRoundTrip(b))
+	})
+}
+
+func testDeltasRoundTrip[N constraints.Integer](t *testing.T, rnd *rand.Rand) {
+	for i := 0; i < 100; i++ {
+		s := make([]N, rnd.Intn(20))
+		for j := range s {
+			s[j] = N(rnd.Uint64())
+		}
+		slices.Sort(s)
+		b := EncodeDeltas(s)
+		got, err := ReadDeltas[N](b)
+		require.NoError(t, err)
+		require.Equal(t, s, got)
+	}
+}
+
+func TestDeltas(t *testing.T) {
+	t.Parallel()
+	rnd := rand.New(rand.NewSource(42))
+	testDeltasRoundTrip[int8](t, rnd)
+	testDeltasRoundTrip[uint16](t, rnd)
+	testDeltasRoundTrip[int64](t, rnd)
+	testDeltasRoundTrip[uint64](t, rnd)
+
+	b := EncodeDeltas([]int{})
+	require.Equal(t, []byte{}, b)
+	s, err := ReadDeltas[int](b)
+	require.NoError(t, err)
+	require.Equal(t, []int{}, s)
+	b = EncodeDeltas([]int{-5})
+	require.Equal(t, EncodeSigned(-5), b)
+	s, err = ReadDeltas[int](b)
+	require.NoError(t, err)
+	require.Equal(t, []int{-5}, s)
+	b = EncodeDeltas([]uint32{1000, 1001, 1003, 1010})
+	require.Equal(t, append(EncodeUnsigned(uint32(1000)), 1, 2, 7), b)
+	unsorted := []int8{100, -100, 127, -128}
+	s8, err := ReadDeltas[int8](EncodeDeltas(unsorted))
+	require.NoError(t, err)
+	require.Equal(t, unsorted, s8)
+
+	_, err = ReadDeltas[uint8](EncodeDeltas([]uint16{1, 300}))
+	require.ErrorContains(t, err, "overflows")
+	_, err = ReadDeltas[uint16]([]byte{1, 0x81})
+	require.ErrorContains(t, err, "parse")
+}
+
+func TestPackFields(t *testing.T) {
+	t.Parallel()
+	widths := []uint8{1, 3, 12, 0, 48}
+	for _, values := range [][]uint64{{0, 0, 0, 0, 0}, {1, 7, 4095, 0, 1<<48 - 1}, {1, 5, 1234, 0, 987654321}} {
+		packed, err := PackFields(values, widths)
+		require.NoError(t, err)
+		require.Equal(t, values, UnpackFields(packed, widths))
+		n, l, err := ReadUnsignedBytes[uint64](EncodeUnsigned(packed))
+		require.NoError(t, err)
+		require.Equal(t, len(EncodeUnsigned(packed)), l)
+		require.Equal(t, values, UnpackFields(n, widths))
+	}
+	packed, err := PackFields([]uint64{1, 2, 3}, []uint8{1, 2, 2})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1|2<<1|3<<3), packed)
+	packed, err = PackFields([]uint64{1<<64 - 1}, []uint8{64})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1<<64 - 1}, UnpackFields(packed, []uint8{64}))
+	packed, err = PackFields(nil, nil)
+	require.NoError(t, err)
+	require.Zero(t, packed)
+
+	_, err = PackFields([]uint64{1, 2}, []uint8{1})
+	require.Error(t, err)
+	_, err = PackFields([]uint64{8}, []uint8{3})
+	require.ErrorContains(t, err, "doesn't fit")
+	_, err = PackFields([]uint64{1}, []uint8{0})
+	require.ErrorContains(t, err, "doesn't fit")
+	_, err = PackFields([]uint64{0, 0}, []uint8{60, 5})
+	require.ErrorContains(t, err, "64 bits")
+	require.Equal(t, []uint64{1<<64 - 1, 0}, UnpackFields(1<<64-1, []uint8{64, 5}))
+}